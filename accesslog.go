@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+var accessLog string
+
+func init() {
+	flag.StringVar(&accessLog, "web.access-log", "off", "Log requests to the exporter: off, errors (4xx and 5xx responses only) or all")
+}
+
+// statusRecorder remembers the status and size of a response. It passes
+// flushing and hijacking through for the streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	// a websocket takes over the connection
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func validateAccessLog() error {
+	switch accessLog {
+	case "off", "errors", "all":
+		return nil
+	}
+	return fmt.Errorf("invalid -web.access-log %q, use off, errors or all", accessLog)
+}
+
+// logRequests logs the requests h handles according to -web.access-log.
+func logRequests(h http.Handler) http.Handler {
+	if accessLog == "off" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if accessLog == "errors" && rec.status < 400 {
+			return
+		}
+		slog.Info("Request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"duration", time.Since(start).Round(time.Microsecond), "bytes", rec.bytes, "remote", r.RemoteAddr, "user_agent", r.UserAgent())
+	})
+}
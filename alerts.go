@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertConfig is a rule of the built-in alert engine. It fires when Condition
+// has held for For, optionally only within a daily time window, and sends an
+// alert.<name> notification to the notifiers.
+type AlertConfig struct {
+	Name      string   `json:"name"`
+	Condition string   `json:"condition"`
+	For       duration `json:"for"`
+	// Between limits the alert to a daily local time window like "23:00-06:00".
+	Between *timeWindow `json:"between"`
+	// Devices and Groups limit the alert to some devices, or those in some groups, all when both are empty.
+	Devices  []string `json:"devices"`
+	Groups   []string `json:"groups"`
+	Severity string   `json:"severity"`
+	// Message is a text/template executed with an alertData.
+	Message string `json:"message"`
+	// Repeat sends the notification again while the alert keeps firing.
+	Repeat duration `json:"repeat"`
+	// Resolved also sends alert.<name>.resolved when the alert stops firing.
+	Resolved bool `json:"resolved"`
+	// ClearFor keeps a firing alert active until the condition has been gone
+	// that long, so a flapping condition doesn't fire and resolve over and over.
+	ClearFor duration `json:"clearFor"`
+	// Count and Window are for obstruction_repeated: how many times the sensor
+	// has to trigger within the window.
+	Count  int      `json:"count"`
+	Window duration `json:"window"`
+
+	condition func(d *deviceState) bool
+	message   *template.Template
+}
+
+// alertData is what alert messages are executed with.
+type alertData struct {
+	Alert    string
+	Device   string
+	Group    string
+	Title    string
+	Duration string
+	Count    int
+	Window   string
+	Status   statusSnapshot
+}
+
+type alertState struct {
+	since    time.Time
+	firing   bool
+	notified time.Time
+	// when the condition stopped holding while firing
+	clearing time.Time
+}
+
+var (
+	alertConditions = map[string]func(d *deviceState) bool{
+		"door_open": func(d *deviceState) bool {
+			return d.Online && d.Status.GarageDoorState != "Closed"
+		},
+		"obstructed": func(d *deviceState) bool {
+			return d.Online && d.Status.GarageObstructed
+		},
+		// the door was closing and reversed or stopped while the beam was blocked
+		"obstruction_while_closing": func(d *deviceState) bool {
+			door := d.Status.GarageDoorState
+			return d.Online && d.Status.GarageObstructed &&
+				(door == "Closing" || (d.PreviousDoorState == "Closing" && door != "Closed"))
+		},
+		// a door that reversed on an obstruction or whose opener faulted
+		"door_stuck": func(d *deviceState) bool {
+			return d.Online && inTransition(d.Status.GarageDoorState)
+		},
+		"door_open_after_dark": func(d *deviceState) bool {
+			return d.openAfterDark()
+		},
+		"light_on": func(d *deviceState) bool {
+			return d.Online && d.Status.GarageLightOn
+		},
+		"motion": func(d *deviceState) bool {
+			return d.Online && d.Status.GarageMotion
+		},
+		// planned outages don't count
+		"offline": func(d *deviceState) bool {
+			return !d.Online && d.LastError != "" && !inMaintenance(d.Name)
+		},
+	}
+
+	alertDefaultMessages = map[string]string{
+		"door_open":                 "Door has been open for {{.Duration}}",
+		"obstructed":                "Door has been obstructed for {{.Duration}}",
+		"obstruction_while_closing": "Door was obstructed while closing",
+		"obstruction_repeated":      "Obstruction sensor triggered {{.Count}} times in {{.Window}}, check the safety beam",
+		"door_stuck":                "Door has been {{.Status.DoorState}} for {{.Duration}}",
+		"door_open_after_dark":      "Door is open after dark",
+		"light_on":                  "Light has been on for {{.Duration}}",
+		"motion":                    "Motion for {{.Duration}}",
+		"offline":                   "Device has been unreachable for {{.Duration}}",
+	}
+
+	alertActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_alert_active",
+		Help: "Whether an alert of the built-in alert engine is firing for a device.",
+	}, []string{"alert", "device"})
+
+	// per alert name and device; guarded by mutex
+	alertStates = map[string]*alertState{}
+)
+
+func init() {
+	prometheus.MustRegister(alertActive)
+	onUpdate(evaluateAlerts)
+}
+
+func (a *AlertConfig) validate() error {
+	if a.Name == "" {
+		return errors.New("name is required")
+	}
+	a.condition = alertConditions[a.Condition]
+	if a.Condition == "obstruction_repeated" {
+		// a single blip of the sensor is ignored, repeated triggers point to a
+		// blocked or misaligned beam
+		if a.Count <= 0 {
+			a.Count = 3
+		}
+		if a.Window <= 0 {
+			a.Window = duration(10 * time.Minute)
+		}
+		a.condition = func(d *deviceState) bool {
+			return d.obstructionsSince(time.Now().Add(-time.Duration(a.Window))) >= a.Count
+		}
+	}
+	if a.condition == nil {
+		return fmt.Errorf("unknown condition %q", a.Condition)
+	}
+	if a.Severity == "" {
+		a.Severity = severityWarning
+	}
+	if err := validSeverity(a.Severity); err != nil {
+		return err
+	}
+	message := a.Message
+	if message == "" {
+		message = alertDefaultMessages[a.Condition]
+	}
+	t, err := template.New(a.Name).Parse(message)
+	if err != nil {
+		return err
+	}
+	a.message = t
+	return nil
+}
+
+func (a *AlertConfig) inWindow(t time.Time) bool {
+	return a.Between == nil || a.Between.contains(t)
+}
+
+func (a *AlertConfig) appliesTo(device string) bool {
+	if len(a.Devices) == 0 && len(a.Groups) == 0 {
+		return true
+	}
+	for _, d := range a.Devices {
+		if d == device {
+			return true
+		}
+	}
+	for _, g := range a.Groups {
+		if g == currentConfig().groups[device] {
+			return true
+		}
+	}
+	return false
+}
+
+func evaluateAlerts(d *deviceState) {
+	now := time.Now()
+	cfg := currentConfig()
+	for i := range cfg.Alerts {
+		a := &cfg.Alerts[i]
+		if !a.appliesTo(d.Name) {
+			continue
+		}
+		key := a.Name + "/" + d.Name
+		state, ok := alertStates[key]
+		if !ok {
+			state = &alertState{}
+			alertStates[key] = state
+		}
+
+		if !a.condition(d) || !a.inWindow(now) {
+			if state.firing && a.ClearFor > 0 {
+				if state.clearing.IsZero() {
+					state.clearing = now
+				}
+				if now.Sub(state.clearing) < time.Duration(a.ClearFor) {
+					continue
+				}
+			}
+			if state.firing {
+				alertActive.WithLabelValues(a.Name, d.Name).Set(0)
+				if a.Resolved {
+					a.notify(d, "alert."+a.Name+".resolved", severityInfo, now.Sub(state.since))
+				}
+			}
+			*state = alertState{}
+			continue
+		}
+
+		state.clearing = time.Time{}
+		if state.since.IsZero() {
+			state.since = now
+		}
+		active := now.Sub(state.since)
+		if active < time.Duration(a.For) {
+			continue
+		}
+		if !state.firing {
+			state.firing = true
+			alertActive.WithLabelValues(a.Name, d.Name).Set(1)
+		} else if a.Repeat <= 0 || now.Sub(state.notified) < time.Duration(a.Repeat) {
+			continue
+		}
+		state.notified = now
+		a.notify(d, "alert."+a.Name, a.Severity, active)
+	}
+}
+
+func (a *AlertConfig) notify(d *deviceState, event, severity string, active time.Duration) {
+	data := alertData{
+		Alert:    a.Name,
+		Device:   d.Name,
+		Group:    currentConfig().groups[d.Name],
+		Title:    displayName(d.Name),
+		Duration: humanDuration(active),
+		Count:    d.obstructionsSince(time.Now().Add(-time.Duration(a.Window))),
+		Window:   humanDuration(time.Duration(a.Window)),
+		Status:   snapshot(d),
+	}
+	var message bytes.Buffer
+	if err := a.message.Execute(&message, data); err != nil {
+		slog.Error("Error rendering alert", "alert", a.Name, "err", err)
+		return
+	}
+	text := message.String()
+	if strings.HasSuffix(event, ".resolved") {
+		text = "Resolved: " + text
+	}
+	notify(Notification{
+		Event:    event,
+		Device:   d.Name,
+		Title:    data.Title,
+		Message:  text,
+		Severity: severity,
+		Time:     time.Now(),
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statusSnapshot is the normalized view of a device served by the JSON API.
+type statusSnapshot struct {
+	Device               string     `json:"device"`
+	DisplayName          string     `json:"displayName"`
+	Location             string     `json:"location"`
+	Maintenance          string     `json:"maintenance"`
+	Online               bool       `json:"online"`
+	LastUpdate           *time.Time `json:"lastUpdate"`
+	LastFetch            *time.Time `json:"lastFetch"`
+	FetchDurationSeconds float64    `json:"fetchDurationSeconds"`
+	LastError            string     `json:"lastError,omitempty"`
+	DoorState            string     `json:"doorState,omitempty"`
+	OpenDurationSeconds  float64    `json:"openDurationSeconds"`
+	DoorCycles           int        `json:"doorCycles"`
+	CloseReversals       int        `json:"closeReversals"`
+	LightOn              bool       `json:"lightOn"`
+	Motion               bool       `json:"motion"`
+	Obstructed           bool       `json:"obstructed"`
+	Status               *Status    `json:"status"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func snapshot(d *deviceState) statusSnapshot {
+	s := statusSnapshot{
+		Device:               d.Name,
+		DisplayName:          targetDisplayName(d.Name),
+		Location:             targetLocation(d.Name),
+		Maintenance:          maintenanceMode(d.Name),
+		Online:               d.Online,
+		LastUpdate:           timePtr(d.LastUpdate),
+		LastFetch:            timePtr(d.LastFetch),
+		FetchDurationSeconds: d.FetchDuration.Seconds(),
+		LastError:            d.LastError,
+		OpenDurationSeconds:  d.openDuration().Seconds(),
+		DoorCycles:           d.Cycles,
+		CloseReversals:       d.CloseReversals,
+	}
+	if d.Seen {
+		status := d.Status
+		s.DoorState = strings.ToLower(status.GarageDoorState)
+		s.LightOn = status.GarageLightOn
+		s.Motion = status.GarageMotion
+		s.Obstructed = status.GarageObstructed
+		s.Status = &status
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing JSON response", "err", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// statusHandler serves /api/v1/status and /api/v1/status/{device}.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/status"), "/")
+
+	visible := visibleDevices(r)
+	snapshots := []statusSnapshot{}
+	for _, d := range allDevices() {
+		if visible != nil && !visible[d.Name] {
+			continue
+		}
+		if name == "" || d.Name == name {
+			d.mu.Lock()
+			snapshots = append(snapshots, snapshot(d))
+			d.mu.Unlock()
+		}
+	}
+
+	if name != "" {
+		if len(snapshots) == 0 {
+			writeJSONError(w, http.StatusNotFound, "unknown device "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshots[0])
+		return
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Device < snapshots[j].Device })
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// targetStatus is what /api/v1/targets shows about a target.
+type targetStatus struct {
+	Name                  string     `json:"name"`
+	URL                   string     `json:"url"`
+	Source                string     `json:"source"`
+	Location              string     `json:"location"`
+	DisplayName           string     `json:"displayName"`
+	PollIntervalSeconds   float64    `json:"pollIntervalSeconds"`
+	Maintenance           string     `json:"maintenance"`
+	Health                string     `json:"health"`
+	LastScrape            *time.Time `json:"lastScrape"`
+	ScrapeDurationSeconds float64    `json:"scrapeDurationSeconds"`
+	LastError             string     `json:"lastError,omitempty"`
+	Failures              int        `json:"failures"`
+}
+
+// targetsHandler serves /api/v1/targets, the devices the exporter watches
+// from the flags, the config and discovery, with the health of their last fetch.
+func targetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	visible := visibleDevices(r)
+	devices := allDevices()
+	result := []targetStatus{}
+	for _, t := range currentTargets() {
+		if visible != nil && !visible[t.Name] {
+			continue
+		}
+		ts := targetStatus{
+			Name:                t.Name,
+			URL:                 t.URL,
+			Source:              t.Source,
+			Location:            targetLocation(t.Name),
+			DisplayName:         targetDisplayName(t.Name),
+			PollIntervalSeconds: t.pollInterval().Seconds(),
+			Maintenance:         maintenanceMode(t.Name),
+			Health:              "unknown",
+		}
+		if u, err := url.Parse(t.URL); err == nil {
+			ts.URL = u.Redacted()
+		}
+		if d, ok := devices[t.Name]; ok {
+			d.mu.Lock()
+			if !d.LastFetch.IsZero() {
+				ts.Health = "down"
+				if d.Online {
+					ts.Health = "up"
+				}
+			}
+			ts.LastScrape, ts.ScrapeDurationSeconds = timePtr(d.LastFetch), d.FetchDuration.Seconds()
+			ts.LastError, ts.Failures = d.LastError, d.Failures
+			d.mu.Unlock()
+		}
+		result = append(result, ts)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	writeJSON(w, http.StatusOK, result)
+}
+
+// proxyHandler serves /proxy/{device}/status.json from the last successful fetch,
+// so other tools can share the exporter's polling instead of hitting the device.
+func proxyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/proxy/")
+	if !strings.HasSuffix(name, "/status.json") {
+		http.NotFound(w, r)
+		return
+	}
+	name = strings.TrimSuffix(name, "/status.json")
+
+	d, ok := allDevices()[name]
+	if visible := visibleDevices(r); visible != nil && !visible[name] {
+		ok = false
+	}
+	var body []byte
+	var updated time.Time
+	if ok {
+		d.mu.Lock()
+		body, updated = d.RawStatus, d.LastUpdate
+		d.mu.Unlock()
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if body == nil {
+		http.Error(w, "No status fetched from the device yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	w.Header().Set("Age", fmt.Sprint(int(time.Since(updated).Seconds())))
+	w.Write(body)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// auditEntry records a control action, whether it was carried out or not.
+type auditEntry struct {
+	Time time.Time `json:"time"`
+	// Actor is the name of the API token, or the automation, that asked for the action.
+	Actor      string `json:"actor"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	Device     string `json:"device"`
+	Control    string `json:"control"`
+	Action     string `json:"action"`
+	// Result is success, failure, denied, rate_limited or pending (waiting for confirmation).
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	auditFile string
+	auditMax  int
+
+	auditActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_audit_entries_total",
+		Help: "Control actions recorded in the audit log, labeled by actor and result.",
+	}, []string{"actor", "result"})
+
+	auditMutex sync.Mutex
+	// recent entries, oldest first; guarded by auditMutex
+	auditLog []auditEntry
+)
+
+func init() {
+	flag.StringVar(&auditFile, "audit.file", "", "File the audit log of control actions is appended to as JSON lines (in memory only when empty)")
+	flag.IntVar(&auditMax, "audit.max", 1000, "Number of recent audit log entries served by /api/v1/audit")
+
+	prometheus.MustRegister(auditActions)
+}
+
+// loadAudit reads the recent entries of the audit log file back into memory.
+func loadAudit() error {
+	if auditFile == "" {
+		return nil
+	}
+	f, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		auditLog = append(auditLog, e)
+		if len(auditLog) > auditMax {
+			auditLog = auditLog[1:]
+		}
+	}
+	return scanner.Err()
+}
+
+func recordAudit(e auditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	auditActions.WithLabelValues(e.Actor, e.Result).Inc()
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditLog = append(auditLog, e)
+	if len(auditLog) > auditMax {
+		auditLog = auditLog[len(auditLog)-auditMax:]
+	}
+	if auditFile == "" {
+		return
+	}
+	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Error("Error writing audit log", "file", auditFile, "err", err)
+		return
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		slog.Error("Error writing audit log", "file", auditFile, "err", err)
+	}
+}
+
+// auditHandler serves /api/v1/audit, newest first. It takes the device, since,
+// until and limit parameters of /api/v1/events, and type for the control.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, ok := authorize(w, r, scopeRead, ""); !ok {
+		return
+	}
+	q, err := parseEventQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	auditMutex.Lock()
+	entries := []auditEntry{}
+	for i := len(auditLog) - 1; i >= 0 && (q.limit < 0 || len(entries) < q.limit); i-- {
+		e := auditLog[i]
+		if q.matches(Event{Time: e.Time, Device: e.Device, Type: e.Control}) {
+			entries = append(entries, e)
+		}
+	}
+	auditMutex.Unlock()
+
+	writeJSON(w, http.StatusOK, entries)
+}
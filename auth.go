@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	scopeRead    = "read"
+	scopeControl = "control"
+	scopeAdmin   = "admin"
+)
+
+// APIToken gives a client access to the API. Control endpoints always need a
+// token with the control scope; the read endpoints only with protectRead.
+type APIToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Scopes are read and/or control, control implies read; admin is for /-/reload and /-/quit.
+	Scopes []string `json:"scopes"`
+	// Devices limits the token to some devices, all when empty.
+	Devices []string `json:"devices"`
+}
+
+func (t *APIToken) validate() error {
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Token) < 16 {
+		return errors.New("token must be at least 16 characters")
+	}
+	for _, s := range t.Scopes {
+		if s != scopeRead && s != scopeControl && s != scopeAdmin {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	return nil
+}
+
+func (t *APIToken) allows(scope, device string) bool {
+	granted := false
+	for _, s := range t.Scopes {
+		if s == scope || s == scopeControl && scope == scopeRead {
+			granted = true
+		}
+	}
+	if !granted {
+		return false
+	}
+	if device == "" || len(t.Devices) == 0 {
+		return true
+	}
+	for _, d := range t.Devices {
+		if d == device {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken is the token of the request's Authorization: Bearer header, nil without one.
+func bearerToken(r *http.Request) []byte {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil
+	}
+	return []byte(strings.TrimPrefix(header, "Bearer "))
+}
+
+// authenticate returns the API token of the request's Authorization: Bearer header, if it is known.
+func authenticate(r *http.Request) *APIToken {
+	token := bearerToken(r)
+	if token == nil {
+		return nil
+	}
+	cfg := currentConfig()
+	for i := range cfg.APITokens {
+		t := &cfg.APITokens[i]
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return t
+		}
+	}
+	return nil
+}
+
+// authorize checks the request has a token with scope for device, which may
+// be empty, and writes the error response if not.
+func authorize(w http.ResponseWriter, r *http.Request, scope, device string) (*APIToken, bool) {
+	t := authenticate(r)
+	if t == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="homekit-ratgdo-exporter"`)
+		writeJSONError(w, http.StatusUnauthorized, "a valid API token is required")
+		return nil, false
+	}
+	if !t.allows(scope, device) {
+		writeJSONError(w, http.StatusForbidden, strings.TrimSpace(fmt.Sprintf("token %s may not %s %s", t.Name, scope, device)))
+		return nil, false
+	}
+	return t, true
+}
+
+// readAccess wraps the read endpoints of the API, which are open unless protectRead is set.
+func readAccess(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if currentConfig().ProtectRead {
+			if _, ok := authorize(w, r, scopeRead, ""); !ok {
+				return
+			}
+		}
+		h(w, r)
+	}
+}
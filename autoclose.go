@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AutoCloseConfig closes doors that have been left open, after warning about it first.
+type AutoCloseConfig struct {
+	After duration `json:"after"`
+	// Warnings are sent this long before the door is closed, e.g. ["5m", "1m"].
+	Warnings []duration `json:"warnings"`
+	// Devices limits auto-close to some devices, all when empty.
+	Devices []string `json:"devices"`
+	// Between limits auto-close to a daily local time window like "22:00-06:00".
+	Between *timeWindow `json:"between"`
+}
+
+type autoCloseState struct {
+	warned int
+	closed bool
+}
+
+var (
+	autoCloses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_auto_close_total",
+		Help: "Doors closed by auto-close, labeled by device and result.",
+	}, []string{"device", "result"})
+
+	// per device, for the current open period; guarded by mutex
+	autoCloseStates = map[string]*autoCloseState{}
+)
+
+func init() {
+	prometheus.MustRegister(autoCloses)
+	onUpdate(autoClose)
+}
+
+func (a *AutoCloseConfig) validate() error {
+	if a.After <= 0 {
+		return errors.New("after is required")
+	}
+	for _, w := range a.Warnings {
+		if w <= 0 || w >= a.After {
+			return fmt.Errorf("warning %s must be between 0 and after", time.Duration(w))
+		}
+	}
+	// earliest warning first
+	sort.Slice(a.Warnings, func(i, j int) bool { return a.Warnings[i] > a.Warnings[j] })
+	return nil
+}
+
+func (a *AutoCloseConfig) appliesTo(device string) bool {
+	if len(a.Devices) == 0 {
+		return true
+	}
+	for _, d := range a.Devices {
+		if d == device {
+			return true
+		}
+	}
+	return false
+}
+
+func autoClose(d *deviceState) {
+	a := currentConfig().AutoClose
+	if a == nil || !a.appliesTo(d.Name) {
+		return
+	}
+	if d.OpenSince.IsZero() {
+		delete(autoCloseStates, d.Name)
+		return
+	}
+	state, ok := autoCloseStates[d.Name]
+	if !ok {
+		state = &autoCloseState{}
+		autoCloseStates[d.Name] = state
+	}
+	if state.closed || !d.Online || (a.Between != nil && !a.Between.contains(time.Now())) {
+		return
+	}
+
+	open := d.openDuration()
+	left := time.Duration(a.After) - open
+	if left > 0 {
+		if state.warned < len(a.Warnings) && left <= time.Duration(a.Warnings[state.warned]) {
+			// skip warnings that are already past, e.g. after a restart
+			for state.warned < len(a.Warnings) && left <= time.Duration(a.Warnings[state.warned]) {
+				state.warned++
+			}
+			autoCloseNotify(d, "autoclose.warning", severityWarning,
+				fmt.Sprintf("Door has been open for %s and will be closed in %s", humanDuration(open), humanDuration(left)))
+		}
+		return
+	}
+
+	// never move the door into something, or while it is already moving
+	if d.Status.GarageObstructed || doorMoving(d) {
+		return
+	}
+	t, ok := findTarget(d.Name)
+	if !ok {
+		return
+	}
+	state.closed = true
+	slog.Info("Auto-closing door", "device", d.Name, "open_for", humanDuration(open))
+	autoCloseNotify(d, "autoclose.closing", severityWarning, fmt.Sprintf("Closing the door, it has been open for %s", humanDuration(open)))
+
+	name := d.Name
+	go func() {
+		entry := auditEntry{Actor: "auto-close", Device: name, Control: "door", Action: "close", Result: "success"}
+		defer func() { recordAudit(entry) }()
+		if err := closeDoor(t); err != nil {
+			slog.Error("Error auto-closing door", "device", name, "err", err)
+			entry.Result, entry.Error = "failure", err.Error()
+			autoCloses.WithLabelValues(name, "failure").Inc()
+			mutex.Lock()
+			autoCloseNotify(d, "autoclose.failed", severityCritical, fmt.Sprintf("Could not close the door: %v", err))
+			mutex.Unlock()
+			return
+		}
+		autoCloses.WithLabelValues(name, "success").Inc()
+	}()
+}
+
+func autoCloseNotify(d *deviceState, event, severity, message string) {
+	notify(Notification{
+		Event:    event,
+		Device:   d.Name,
+		Title:    displayName(d.Name),
+		Message:  message,
+		Severity: severity,
+		Time:     time.Now(),
+	})
+}
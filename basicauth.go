@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	basicAuthMutex sync.Mutex
+	// checking a bcrypt hash is slow on purpose, so remember the credentials
+	// that matched, by their digest, rather than checking every scrape
+	basicAuthCache = map[[sha256.Size]byte]bool{}
+)
+
+func checkPassword(user, password string) bool {
+	hash, ok := currentConfig().BasicAuthUsers[user]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	basicAuthMutex.Lock()
+	cached := basicAuthCache[key]
+	basicAuthMutex.Unlock()
+	if cached {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+	basicAuthMutex.Lock()
+	basicAuthCache[key] = true
+	basicAuthMutex.Unlock()
+	return true
+}
+
+// runHashPassword prints the bcrypt hash of the first line of stdin.
+func runHashPassword() {
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		fatal("No password given on stdin")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fatal("Error hashing password", "err", err)
+	}
+	fmt.Println(string(hash))
+}
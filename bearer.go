@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	webBearerTokenFile string
+	// static tokens every request needs one of, when there are any; replaced
+	// as a whole on reload, see currentBearerTokens
+	webBearerTokens atomic.Pointer[[]string]
+)
+
+func init() {
+	flag.StringVar(&webBearerTokenFile, "web.bearer-token-file", "", "File with bearer tokens, one per line, one of which every request needs (also read from $RATGDO_EXPORTER_BEARER_TOKEN)")
+}
+
+// loadBearerTokens reads the static bearer tokens from -web.bearer-token-file and the environment.
+func loadBearerTokens() error {
+	var tokens []string
+	if t := strings.TrimSpace(os.Getenv("RATGDO_EXPORTER_BEARER_TOKEN")); t != "" {
+		tokens = append(tokens, t)
+	}
+	if webBearerTokenFile != "" {
+		b, err := ioutil.ReadFile(webBearerTokenFile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+				tokens = append(tokens, t)
+			}
+		}
+		if len(tokens) == 0 {
+			return fmt.Errorf("no tokens in %s", webBearerTokenFile)
+		}
+	}
+	for _, t := range tokens {
+		if len(t) < 16 {
+			return fmt.Errorf("bearer tokens must be at least 16 characters")
+		}
+	}
+	webBearerTokens.Store(&tokens)
+	return nil
+}
+
+// currentBearerTokens returns the static bearer tokens. The slice must not be changed.
+func currentBearerTokens() []string {
+	if t := webBearerTokens.Load(); t != nil {
+		return *t
+	}
+	return nil
+}
+
+// validBearerToken tells whether the request has one of the static bearer tokens.
+func validBearerToken(r *http.Request, tokens []string) bool {
+	token := bearerToken(r)
+	if token == nil {
+		return false
+	}
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
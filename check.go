@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of Nagios plugins.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+func checkUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s check [flags] <door|device> [-target device] [check flags]
+
+Checks a device like a Nagios or Icinga plugin: prints one line with perfdata
+and exits with 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN).
+
+  door     How long the door has been open, and whether it is obstructed
+  device   Whether the device can be reached, and its free heap
+`, os.Args[0])
+	os.Exit(checkUnknown)
+}
+
+// checkResult exits with the state of a check, with its message and perfdata.
+func checkResult(name string, state int, message string, perfdata ...string) {
+	fmt.Printf("%s %s - %s", strings.ToUpper(name), checkStates[state], message)
+	if len(perfdata) > 0 {
+		fmt.Printf(" | %s", strings.Join(perfdata, " "))
+	}
+	fmt.Println()
+	os.Exit(state)
+}
+
+// thresholdState is the state of value against the warning and critical
+// thresholds, each 0 when not set.
+func thresholdState(value, warn, crit float64) int {
+	switch {
+	case crit > 0 && value >= crit:
+		return checkCritical
+	case warn > 0 && value >= warn:
+		return checkWarning
+	}
+	return checkOK
+}
+
+// thresholds is the warn;crit part of perfdata, empty where not set.
+func thresholds(warn, crit float64) string {
+	s := func(v float64) string {
+		if v <= 0 {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	return s(warn) + ";" + s(crit)
+}
+
+// runCheck runs a check against one device, fetched directly.
+func runCheck(args []string) {
+	if len(args) == 0 {
+		checkUsage()
+	}
+	name := args[0]
+	fs := flag.NewFlagSet("check "+name, flag.ExitOnError)
+	fs.Usage = checkUsage
+	target := fs.String("target", "", "Device to check: the name of a device in -json-address or the config, or its address (only needed with several devices)")
+	warnOpen := fs.Float64("warn-open", 600, "WARNING when the door has been open this many seconds (0 disables)")
+	critOpen := fs.Float64("crit-open", 1800, "CRITICAL when the door has been open this many seconds (0 disables)")
+	warnObstructed := fs.Bool("warn-obstructed", true, "WARNING when the door is obstructed")
+	warnHeap := fs.Float64("warn-heap", 0, "WARNING when the device's free heap is below this many bytes (0 disables)")
+	critHeap := fs.Float64("crit-heap", 0, "CRITICAL when the device's free heap is below this many bytes (0 disables)")
+	fs.Parse(args[1:])
+	if name != "door" && name != "device" {
+		checkUsage()
+	}
+
+	t, err := checkTarget(*target)
+	if err != nil {
+		checkResult(name, checkUnknown, err.Error())
+	}
+	start := time.Now()
+	status, err := fetchStatus(t)
+	elapsed := time.Since(start).Seconds()
+	if err != nil {
+		if name == "device" {
+			checkResult(name, checkCritical, fmt.Sprintf("%s unreachable: %v", t.Name, err))
+		}
+		checkResult(name, checkUnknown, fmt.Sprintf("%s unreachable: %v", t.Name, err))
+	}
+
+	switch name {
+	case "door":
+		door := status.GarageDoorState
+		var open float64
+		if door != "Closed" {
+			// the firmware's clock is its uptime in milliseconds
+			since := int64(status.LastDoorUpdateAt)
+			if since <= 0 || since > status.UpTime {
+				since = 0
+			}
+			open = float64(status.UpTime-since) / 1000
+		}
+		state := thresholdState(open, *warnOpen, *critOpen)
+		message := fmt.Sprintf("%s is %s", t.Name, strings.ToLower(door))
+		if open > 0 {
+			message += " for " + humanDuration(time.Duration(open)*time.Second)
+		}
+		if status.GarageObstructed {
+			message += ", obstructed"
+			if *warnObstructed && state == checkOK {
+				state = checkWarning
+			}
+		}
+		checkResult(name, state, message,
+			fmt.Sprintf("open_seconds=%.0fs;%s;0", open, thresholds(*warnOpen, *critOpen)),
+			fmt.Sprintf("obstructed=%d", int(boolToFloat(status.GarageObstructed))))
+	case "device":
+		state := checkOK
+		heap := float64(status.FreeHeap)
+		switch {
+		case *critHeap > 0 && heap < *critHeap:
+			state = checkCritical
+		case *warnHeap > 0 && heap < *warnHeap:
+			state = checkWarning
+		}
+		message := fmt.Sprintf("%s up for %s, firmware %s, %d bytes free heap", t.Name,
+			humanDuration(time.Duration(status.UpTime)*time.Millisecond), status.FirmwareVersion, status.FreeHeap)
+		checkResult(name, state, message,
+			fmt.Sprintf("time=%.3fs;;;0", elapsed),
+			fmt.Sprintf("free_heap=%dB;%s;0", status.FreeHeap, thresholds(*warnHeap, *critHeap)),
+			fmt.Sprintf("min_heap=%dB;;;0", status.MinHeap),
+			fmt.Sprintf("crash_count=%d;;;0", status.CrashCount),
+			fmt.Sprintf("uptime=%.0fs;;;0", float64(status.UpTime)/1000))
+	}
+}
+
+// checkTarget finds the device to check by name or address, or the only one.
+func checkTarget(name string) (Target, error) {
+	if strings.Contains(name, "://") {
+		ts, err := parseTargets(name)
+		if err != nil {
+			return Target{}, err
+		}
+		return ts[0], nil
+	}
+	if name != "" {
+		if t, ok := findTarget(name); ok {
+			return t, nil
+		}
+		return Target{}, fmt.Errorf("unknown device %s", name)
+	}
+	ts := currentTargets()
+	if len(ts) != 1 {
+		return Target{}, fmt.Errorf("%d devices, choose one with -target", len(ts))
+	}
+	return ts[0], nil
+}
+
+// fetchStatus fetches the status of a device once, without recording it.
+func fetchStatus(t Target) (Status, error) {
+	var status Status
+	if t.hap() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		body, err := fetchHAP(ctx, t)
+		if err == nil {
+			err = json.Unmarshal(body, &status)
+		}
+		return status, err
+	}
+	req, err := t.newRequest(context.Background(), http.MethodGet, t.URL, nil)
+	if err != nil {
+		return status, err
+	}
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := t.do(client, req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inflightFetch is a fetch of a device that concurrent scrapes and polls wait for together.
+type inflightFetch struct {
+	done   chan struct{}
+	err    error
+	cancel context.CancelFunc
+	// guarded by inflightMutex
+	waiters int
+}
+
+// fetchDeadlineKey is the context key of the time the fetches of a scrape
+// have to be done by.
+type fetchDeadlineKey struct{}
+
+var (
+	maxConcurrentScrapes int
+	fetchMinInterval     time.Duration
+	fetchSoftTimeout     time.Duration
+	scrapeTimeoutOffset  time.Duration
+
+	inflightMutex sync.Mutex
+	// concurrent fetches of the same device share one request to it; guarded by inflightMutex
+	inflight = map[string]*inflightFetch{}
+
+	sharedFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_shared_fetches_total",
+		Help: "Fetches that waited for one of the same device already in flight instead of making another request.",
+	}, []string{"device"})
+	cachedFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_cached_fetches_total",
+		Help: "Fetches answered from the last result because the device was fetched less than -fetch.min-interval ago.",
+	}, []string{"device"})
+	cancelledFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_cancelled_fetches_total",
+		Help: "Fetches aborted because every scrape waiting for them went away.",
+	}, []string{"device"})
+	staleFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_stale_fetches_total",
+		Help: "Scrapes that got the last result of a device because fetching it took longer than -fetch.soft-timeout.",
+	}, []string{"device"})
+	rejectedScrapes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_rejected_scrapes_total",
+		Help: "Scrapes that gave up waiting for one of the -web.max-concurrent-scrapes slots.",
+	})
+)
+
+func init() {
+	flag.DurationVar(&fetchSoftTimeout, "fetch.soft-timeout", 0, "Answer a scrape with the last result of a device that takes longer than this to fetch, and let the fetch finish in the background (0 always waits)")
+	flag.DurationVar(&fetchMinInterval, "fetch.min-interval", 0, "Fetch a device at most once in this long, answering scrapes and polls in between from the last result (0 fetches every time)")
+	flag.IntVar(&maxConcurrentScrapes, "web.max-concurrent-scrapes", 0, "Run at most this many /metrics scrapes at a time, queuing the rest (0 is unlimited)")
+	flag.DurationVar(&scrapeTimeoutOffset, "web.scrape-timeout-offset", 500*time.Millisecond, "Fetches for a scrape give up this long before the timeout Prometheus sends in X-Prometheus-Scrape-Timeout-Seconds")
+
+	prometheus.MustRegister(sharedFetches, cachedFetches, cancelledFetches, staleFetches, rejectedScrapes)
+	prometheus.MustRegister(dataAgeCollector{
+		desc: prometheus.NewDesc("homekit_ratgdo_data_age_seconds", "Seconds since the device was last fetched successfully, how old its other series are.", []string{"location", "device", "displayName"}, nil),
+	})
+}
+
+// fetchShared fetches t, or waits for the result of a fetch of t already in
+// flight, unless it was fetched within -fetch.min-interval.
+func fetchShared(ctx context.Context, t Target) error {
+	if fetchMinInterval > 0 {
+		d := getDevice(t.Name)
+		d.mu.Lock()
+		recent, lastError := time.Since(d.LastFetch) < fetchMinInterval, d.LastError
+		d.mu.Unlock()
+		if recent {
+			cachedFetches.WithLabelValues(t.Name).Inc()
+			if lastError != "" {
+				return errors.New(lastError)
+			}
+			return nil
+		}
+	}
+	inflightMutex.Lock()
+	f, ok := inflight[t.Name]
+	if ok {
+		sharedFetches.WithLabelValues(t.Name).Inc()
+	} else {
+		// the fetch belongs to all its waiters, it's only cancelled once none are left
+		fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &inflightFetch{done: make(chan struct{}), cancel: cancel}
+		inflight[t.Name] = f
+		go func() {
+			defer func() {
+				// a payload that breaks the exporter only fails this device
+				if r := recover(); r != nil {
+					slog.Error("Panic fetching device", "device", t.Name, "panic", r, "stack", string(debug.Stack()))
+					capturePanic(r)
+					f.err = fmt.Errorf("panic: %v", r)
+					deviceUp.WithLabelValues(targetLocation(t.Name), t.Name, targetDisplayName(t.Name)).Set(0)
+				}
+				inflightMutex.Lock()
+				if inflight[t.Name] == f {
+					delete(inflight, t.Name)
+				}
+				inflightMutex.Unlock()
+				cancel()
+				close(f.done)
+			}()
+			_, f.err = fetchData(fetchCtx, t)
+		}()
+	}
+	f.waiters++
+	inflightMutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		inflightMutex.Lock()
+		defer inflightMutex.Unlock()
+		f.waiters--
+		if f.waiters == 0 {
+			f.cancel()
+			if inflight[t.Name] == f {
+				delete(inflight, t.Name)
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// fetchOrCached fetches t for a scrape. When that takes longer than
+// -fetch.soft-timeout and the device was fetched before, the scrape goes on
+// with its last result while the fetch finishes for the next one.
+func fetchOrCached(ctx context.Context, t Target) error {
+	d := getDevice(t.Name)
+	d.mu.Lock()
+	seen := d.Seen
+	d.mu.Unlock()
+	if fetchSoftTimeout <= 0 || !seen {
+		return fetchShared(ctx, t)
+	}
+	done := make(chan error, 1)
+	go func() {
+		// neither cancelled with the scrape nor bound to its deadline, only
+		// to -fetch.timeout; it keeps the scrape's span
+		background := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+		done <- fetchShared(background, t)
+	}()
+	timer := time.NewTimer(fetchSoftTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		slog.Debug("Fetch is slow, using the last result", "device", t.Name)
+		staleFetches.WithLabelValues(t.Name).Inc()
+		// the last result may be that the device couldn't be fetched
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.LastError != "" {
+			return errors.New(d.LastError)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dataAgeCollector exposes how old the data of each device is, which grows
+// while it is answered from the last result or can't be reached.
+type dataAgeCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c dataAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c dataAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for name, d := range allDevices() {
+		d.mu.Lock()
+		lastUpdate := d.LastUpdate
+		d.mu.Unlock()
+		if lastUpdate.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, now.Sub(lastUpdate).Seconds(), targetLocation(name), name, targetDisplayName(name))
+	}
+}
+
+// limitScrapes queues requests to h beyond -web.max-concurrent-scrapes until
+// a slot frees up or the client gives up.
+func limitScrapes(h http.HandlerFunc) http.HandlerFunc {
+	if maxConcurrentScrapes <= 0 {
+		return h
+	}
+	scrapeSlots := make(chan struct{}, maxConcurrentScrapes)
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case scrapeSlots <- struct{}{}:
+			defer func() { <-scrapeSlots }()
+			h(w, r)
+		case <-r.Context().Done():
+			rejectedScrapes.Inc()
+			http.Error(w, "Too many concurrent scrapes", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// honorScrapeTimeout bounds the fetches for a scrape by the timeout Prometheus
+// sends with it, less -web.scrape-timeout-offset, so the scrape is answered
+// before Prometheus gives up on it.
+func honorScrapeTimeout(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+		if err != nil || seconds <= 0 {
+			h(w, r)
+			return
+		}
+		timeout := time.Duration(seconds * float64(time.Second))
+		budget := timeout - scrapeTimeoutOffset
+		if budget <= 0 {
+			budget = timeout / 2
+		}
+		// the fetches time out first, so the devices count as down and not
+		// as cancelled; the scrape stops waiting for a fetch somebody else
+		// started halfway between that and the timeout
+		now := time.Now()
+		ctx := context.WithValue(r.Context(), fetchDeadlineKey{}, now.Add(budget))
+		ctx, cancel := context.WithDeadline(ctx, now.Add((budget+timeout)/2))
+		defer cancel()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// fetchDeadline is when a fetch started now gives up: after -fetch.timeout,
+// or earlier when the scrape it is for has to be answered by then.
+func fetchDeadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(fetchTimeout)
+	if d, ok := ctx.Value(fetchDeadlineKey{}).(time.Time); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the optional JSON file given with -config, for settings that don't fit in flags.
+type Config struct {
+	Webhooks  []WebhookConfig  `json:"webhooks"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+	Alerts    []AlertConfig    `json:"alerts"`
+	// QuietHours applies to notifiers that don't set their own.
+	QuietHours []timeWindow `json:"quietHours"`
+	// DoorLeftOpenAfter sends a door.left_open notification once a door has been open this long.
+	DoorLeftOpenAfter duration         `json:"doorLeftOpenAfter"`
+	AutoClose         *AutoCloseConfig `json:"autoClose"`
+	CrashRate         *CrashRateConfig `json:"crashRate"`
+	Reports           *ReportsConfig   `json:"reports"`
+	APITokens         []APIToken       `json:"apiTokens"`
+	Control           *ControlConfig   `json:"control"`
+	// ProtectRead requires a token with the read scope for the JSON API and streams too.
+	ProtectRead bool `json:"protectRead"`
+	// BasicAuthUsers maps user names to bcrypt password hashes; when set every
+	// request needs one of them, or an API token.
+	BasicAuthUsers map[string]string `json:"basicAuthUsers"`
+	// Tenants share the exporter, each only seeing its own devices.
+	Tenants []TenantConfig `json:"tenants"`
+	// Targets has settings per device, by its name.
+	Targets map[string]TargetConfig `json:"targets"`
+	// Groups are zones of devices, like "main-house" or "barn", by name.
+	Groups map[string][]string `json:"groups"`
+	// Coordinates are where the devices are, for the door_open_after_dark
+	// alert condition; devices may have their own in Targets.
+	Coordinates *Coordinates `json:"coordinates"`
+
+	// the tenant and group of each device that has one
+	tenants map[string]string
+	groups  map[string]string
+}
+
+// duration is a time.Duration that is written as a string like "30s" in the config file.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// timeWindow is a daily window in local time, written as "23:00-06:00" in the
+// config file. It may wrap around midnight.
+type timeWindow struct {
+	start, end time.Duration
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *timeWindow) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid time window %q, use HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseClock(parts[0]); err != nil {
+		return err
+	}
+	w.end, err = parseClock(parts[1])
+	return err
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.start <= w.end {
+		return clock >= w.start && clock < w.end
+	}
+	return clock >= w.start || clock < w.end
+}
+
+func inAnyWindow(windows []timeWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	configFile string
+	// replaced as a whole on reload, see currentConfig
+	configPtr atomic.Pointer[Config]
+)
+
+func init() {
+	flag.StringVar(&configFile, "config", "", "Path to a JSON config file")
+	configPtr.Store(&Config{})
+}
+
+// currentConfig returns the config. It must not be changed.
+func currentConfig() *Config {
+	return configPtr.Load()
+}
+
+// readConfig reads the config from -config.url or -config.
+func readConfig() (*Config, error) {
+	if configURL != "" {
+		return fetchConfig(true)
+	}
+	return loadConfig(configFile)
+}
+
+func loadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(b, path)
+}
+
+// parseConfig parses and validates the config read from source.
+func parseConfig(b []byte, source string) (*Config, error) {
+	c := &Config{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", source, err)
+	}
+	for name, t := range c.Targets {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("target %s: %v", name, err)
+		}
+	}
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return nil, fmt.Errorf("webhook %d: %v", i+1, err)
+		}
+	}
+	for i := range c.Notifiers {
+		if err := c.Notifiers[i].validate(c.QuietHours); err != nil {
+			return nil, fmt.Errorf("notifier %d: %v", i+1, err)
+		}
+	}
+	if c.Coordinates != nil {
+		if err := c.Coordinates.validate(); err != nil {
+			return nil, fmt.Errorf("coordinates: %v", err)
+		}
+	}
+	for i := range c.Alerts {
+		if err := c.Alerts[i].validate(); err != nil {
+			return nil, fmt.Errorf("alert %d: %v", i+1, err)
+		}
+		if c.Alerts[i].Condition == "door_open_after_dark" && !c.hasCoordinates() {
+			return nil, fmt.Errorf("alert %d: door_open_after_dark needs coordinates", i+1)
+		}
+	}
+	if err := c.validateGroups(); err != nil {
+		return nil, err
+	}
+	for i := range c.APITokens {
+		if err := c.APITokens[i].validate(); err != nil {
+			return nil, fmt.Errorf("API token %d: %v", i+1, err)
+		}
+	}
+	if err := c.validateTenants(); err != nil {
+		return nil, err
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("basic auth user %s: invalid bcrypt hash: %v", user, err)
+		}
+	}
+	if c.Control != nil {
+		if err := c.Control.validate(); err != nil {
+			return nil, fmt.Errorf("control: %v", err)
+		}
+	}
+	if c.AutoClose != nil {
+		if err := c.AutoClose.validate(); err != nil {
+			return nil, fmt.Errorf("autoClose: %v", err)
+		}
+	}
+	if c.Reports != nil {
+		if err := c.Reports.validate(); err != nil {
+			return nil, fmt.Errorf("reports: %v", err)
+		}
+	}
+	if c.CrashRate != nil {
+		if err := c.CrashRate.validate(); err != nil {
+			return nil, fmt.Errorf("crashRate: %v", err)
+		}
+	}
+	return c, nil
+}
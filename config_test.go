@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimeWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 1, hour, min, 30, 0, time.Local)
+	}
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"08:00-17:30", at(8, 0), true},
+		{"08:00-17:30", at(12, 0), true},
+		{"08:00-17:30", at(17, 29), true},
+		{"08:00-17:30", at(17, 30), false},
+		{"08:00-17:30", at(7, 59), false},
+		// wraps around midnight
+		{"23:00-06:00", at(23, 0), true},
+		{"23:00-06:00", at(0, 0), true},
+		{"23:00-06:00", at(5, 59), true},
+		{"23:00-06:00", at(6, 0), false},
+		{"23:00-06:00", at(12, 0), false},
+		{" 9:05 - 9:10 ", at(9, 7), true},
+		// an empty window
+		{"10:00-10:00", at(10, 0), false},
+	}
+	for _, tt := range tests {
+		var w timeWindow
+		if err := json.Unmarshal([]byte(`"`+tt.window+`"`), &w); err != nil {
+			t.Fatalf("%q: %v", tt.window, err)
+		}
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.window, tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestTimeWindowInvalid(t *testing.T) {
+	for _, s := range []string{`"08:00"`, `"08:00-"`, `"8am-5pm"`, `"25:00-06:00"`, `"08:00-09:00-10:00"`, `480`} {
+		var w timeWindow
+		if err := json.Unmarshal([]byte(s), &w); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+}
+
+func TestInAnyWindow(t *testing.T) {
+	var windows []timeWindow
+	if err := json.Unmarshal([]byte(`["01:00-02:00", "22:00-23:00"]`), &windows); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hour int
+		want bool
+	}{
+		{1, true},
+		{22, true},
+		{12, false},
+	}
+	for _, tt := range tests {
+		if got := inAnyWindow(windows, time.Date(2024, 3, 1, tt.hour, 15, 0, 0, time.Local)); got != tt.want {
+			t.Errorf("%d:15 = %v, want %v", tt.hour, got, tt.want)
+		}
+	}
+	if inAnyWindow(nil, time.Now()) {
+		t.Error("no windows contain the time")
+	}
+}
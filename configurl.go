@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	configURL          string
+	configURLInterval  time.Duration
+	configURLTokenFile string
+
+	configURLMutex sync.Mutex
+	// of the last config fetched from -config.url; guarded by configURLMutex
+	configETag string
+)
+
+func init() {
+	flag.StringVar(&configURL, "config.url", "", "URL to fetch the JSON config from instead of -config; user:password@ in it is sent as basic auth")
+	flag.DurationVar(&configURLInterval, "config.url-interval", time.Minute, "How often to check -config.url for changes (0 only fetches it on start and reload)")
+	flag.StringVar(&configURLTokenFile, "config.url-token-file", "", "File with a bearer token to fetch -config.url with")
+}
+
+// configSource is where the config comes from, for logs.
+func configSource() string {
+	if configURL != "" {
+		if u, err := url.Parse(configURL); err == nil {
+			return u.Redacted()
+		}
+	}
+	return configFile
+}
+
+// fetchConfig fetches the config from -config.url. Unless always is set it
+// returns nil when the config didn't change since it was fetched last.
+func fetchConfig(always bool) (*Config, error) {
+	req, err := http.NewRequest(http.MethodGet, configURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "homekit-ratgdo-exporter/"+version)
+	if configURLTokenFile != "" {
+		// read every time, so the token can be rotated
+		token, err := os.ReadFile(configURLTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	configURLMutex.Lock()
+	if !always && configETag != "" {
+		req.Header.Set("If-None-Match", configETag)
+	}
+	configURLMutex.Unlock()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	c, err := parseConfig(b, configSource())
+	if err != nil {
+		return nil, err
+	}
+	configURLMutex.Lock()
+	configETag = resp.Header.Get("ETag")
+	configURLMutex.Unlock()
+	return c, nil
+}
+
+// watchConfigURL applies the config from -config.url whenever it changes.
+func watchConfigURL() {
+	defer reportPanic()
+	for {
+		time.Sleep(configURLInterval)
+		c, err := fetchConfig(false)
+		if err == nil && c == nil {
+			continue
+		}
+		if err == nil {
+			err = applyConfig(c)
+		}
+		if err != nil {
+			configReloadSuccess.Set(0)
+			slog.Error("Error fetching config", "url", configSource(), "err", err)
+			continue
+		}
+		configReloadSuccess.Set(1)
+		configReloadTime.SetToCurrentTime()
+		slog.Info("Config changed", "url", configSource())
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The homekit-ratgdo firmware takes commands as form posts to its /setgdo endpoint.
+
+// controls maps a control and an action to the form posted to /setgdo.
+var controls = map[string]map[string]func(d *deviceState) url.Values{
+	"door": {
+		"open":  setgdo("garageDoorState", "1"),
+		"close": setgdo("garageDoorState", "0"),
+		"stop":  setgdo("garageDoorStop", "1"),
+	},
+	"light": {
+		"on":  setgdo("garageLightOn", "1"),
+		"off": setgdo("garageLightOn", "0"),
+		"toggle": func(d *deviceState) url.Values {
+			if d.Status.GarageLightOn {
+				return url.Values{"garageLightOn": {"0"}}
+			}
+			return url.Values{"garageLightOn": {"1"}}
+		},
+	},
+	// the remote lockout of the wall console
+	"lock": {
+		"engage":    setgdo("garageLockState", "1"),
+		"disengage": setgdo("garageLockState", "0"),
+	},
+}
+
+var (
+	controlClient = &http.Client{Timeout: 10 * time.Second}
+
+	actuations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_actuations_total",
+		Help: "Control requests sent to devices through the API, labeled by device, control, action and result.",
+	}, []string{"device", "control", "action", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(actuations)
+}
+
+func setgdo(key, value string) func(d *deviceState) url.Values {
+	return func(d *deviceState) url.Values {
+		return url.Values{key: {value}}
+	}
+}
+
+// postDevice posts form to path on the device.
+func postDevice(t Target, path string, form url.Values) error {
+	if t.hap() {
+		return errors.New("not supported for devices read over HomeKit")
+	}
+	req, err := t.newRequest(context.Background(), http.MethodPost, t.endpoint(path), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.do(controlClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, path)
+	}
+	return nil
+}
+
+// closeDoor tells the device to close the door.
+func closeDoor(t Target) error {
+	return postDevice(t, "/setgdo", controls["door"]["close"](nil))
+}
+
+// doorMoving reports whether the door is in motion; nothing may reboot or command the device then.
+func doorMoving(d *deviceState) bool {
+	switch d.Status.GarageDoorState {
+	case "Opening", "Closing":
+		return true
+	}
+	return false
+}
+
+func actionNames(actions map[string]func(d *deviceState) url.Values) string {
+	var names []string
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// controlHandler serves POST /api/v1/devices/{device}/{control} with a JSON
+// body like {"action": "close"}, or an action form value.
+func controlHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/devices/"), "/"), "/")
+	if len(parts) != 2 || controls[parts[1]] == nil && parts[1] != "maintenance" {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name, control := parts[0], parts[1]
+
+	var body struct {
+		Action  string `json:"action"`
+		Confirm string `json:"confirm"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	} else {
+		body.Action, body.Confirm = r.FormValue("action"), r.FormValue("confirm")
+	}
+	if control == "maintenance" {
+		maintenanceHandler(w, r, name, body.Action, auditEntry{RemoteAddr: r.RemoteAddr, Device: name, Control: control, Action: body.Action})
+		return
+	}
+	action := controls[control][body.Action]
+	if action == nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown action %q, use one of %s", body.Action, actionNames(controls[control])))
+		return
+	}
+	entry := auditEntry{RemoteAddr: r.RemoteAddr, Device: name, Control: control, Action: body.Action}
+	token, ok := authorize(w, r, scopeControl, name)
+	if !ok {
+		entry.Actor, entry.Result = "anonymous", "denied"
+		if t := authenticate(r); t != nil {
+			entry.Actor = t.Name
+		}
+		recordAudit(entry)
+		return
+	}
+	entry.Actor = token.Name
+
+	t, ok := findTarget(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown device "+name)
+		return
+	}
+	// only the request that carries the action out counts against the rate limits
+	confirming := needsConfirmation(control, body.Action)
+	if confirming && body.Confirm == "" {
+		id, expires := requestConfirmation(token.Name, name, control+"/"+body.Action)
+		entry.Result = "pending"
+		recordAudit(entry)
+		writeJSON(w, http.StatusAccepted, map[string]string{"device": name, "control": control, "action": body.Action, "result": "confirm", "confirm": id, "expires": expires.Format(time.RFC3339)})
+		return
+	}
+	if !allowControl(token.Name, name) {
+		entry.Result = "rate_limited"
+		recordAudit(entry)
+		writeJSONError(w, http.StatusTooManyRequests, "too many control requests, try again later")
+		return
+	}
+	if confirming {
+		if !confirm(body.Confirm, token.Name, name, control+"/"+body.Action) {
+			entry.Result = "denied"
+			entry.Error = "invalid or expired confirmation"
+			recordAudit(entry)
+			writeJSONError(w, http.StatusForbidden, "invalid or expired confirmation")
+			return
+		}
+	}
+	d := getDevice(name)
+	d.mu.Lock()
+	form := action(d)
+	d.mu.Unlock()
+
+	slog.Info("Control requested", "device", name, "control", control, "action", body.Action, "token", token.Name, "remote", r.RemoteAddr)
+	if err := postDevice(t, "/setgdo", form); err != nil {
+		slog.Error("Error sending control", "device", name, "control", control, "action", body.Action, "err", err)
+		actuations.WithLabelValues(name, control, body.Action, "failure").Inc()
+		entry.Result, entry.Error = "failure", err.Error()
+		recordAudit(entry)
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	actuations.WithLabelValues(name, control, body.Action, "success").Inc()
+	entry.Result = "success"
+	recordAudit(entry)
+	writeJSON(w, http.StatusOK, map[string]string{"device": name, "control": control, "action": body.Action, "result": "ok"})
+}
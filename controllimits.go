@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// ControlConfig limits what a token can do with the control endpoints.
+type ControlConfig struct {
+	// TokenRateLimit and DeviceRateLimit cap control requests per token and
+	// per device to that many per RatePeriod.
+	TokenRateLimit  int      `json:"tokenRateLimit"`
+	DeviceRateLimit int      `json:"deviceRateLimit"`
+	RatePeriod      duration `json:"ratePeriod"`
+	// ConfirmOpen makes opening a door a two step operation: the first request
+	// returns a confirmation id that has to be sent back within ConfirmTimeout.
+	ConfirmOpen    bool     `json:"confirmOpen"`
+	ConfirmTimeout duration `json:"confirmTimeout"`
+}
+
+type pendingConfirmation struct {
+	token  string
+	device string
+	// the control and action, like door/open
+	action  string
+	expires time.Time
+}
+
+var (
+	controlLimitMutex sync.Mutex
+	// guarded by controlLimitMutex
+	controlRequests = map[string][]time.Time{}
+	confirmations   = map[string]pendingConfirmation{}
+)
+
+func (c *ControlConfig) validate() error {
+	if c.RatePeriod <= 0 {
+		c.RatePeriod = duration(time.Hour)
+	}
+	if c.ConfirmTimeout <= 0 {
+		c.ConfirmTimeout = duration(30 * time.Second)
+	}
+	return nil
+}
+
+// allowControl records a control request by token for device and reports
+// whether it is within the rate limits.
+func allowControl(token, device string) bool {
+	c := currentConfig().Control
+	if c == nil {
+		return true
+	}
+	controlLimitMutex.Lock()
+	defer controlLimitMutex.Unlock()
+
+	now := time.Now()
+	within := func(key string, limit int) bool {
+		if limit <= 0 {
+			return true
+		}
+		times := controlRequests[key]
+		for len(times) > 0 && now.Sub(times[0]) > time.Duration(c.RatePeriod) {
+			times = times[1:]
+		}
+		controlRequests[key] = times
+		return len(times) < limit
+	}
+	tokenKey, deviceKey := "token/"+token, "device/"+device
+	if !within(tokenKey, c.TokenRateLimit) || !within(deviceKey, c.DeviceRateLimit) {
+		return false
+	}
+	controlRequests[tokenKey] = append(controlRequests[tokenKey], now)
+	controlRequests[deviceKey] = append(controlRequests[deviceKey], now)
+	return true
+}
+
+func needsConfirmation(control, action string) bool {
+	c := currentConfig().Control
+	return c != nil && c.ConfirmOpen && control == "door" && action == "open"
+}
+
+// requestConfirmation returns the id the token has to confirm the action with.
+func requestConfirmation(token, device, action string) (string, time.Time) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	expires := time.Now().Add(time.Duration(currentConfig().Control.ConfirmTimeout))
+
+	controlLimitMutex.Lock()
+	defer controlLimitMutex.Unlock()
+	for k, p := range confirmations {
+		if time.Now().After(p.expires) {
+			delete(confirmations, k)
+		}
+	}
+	confirmations[id] = pendingConfirmation{token: token, device: device, action: action, expires: expires}
+	return id, expires
+}
+
+// confirm consumes a confirmation id, which only works once, for the same token, device and action.
+func confirm(id, token, device, action string) bool {
+	controlLimitMutex.Lock()
+	defer controlLimitMutex.Unlock()
+	p, ok := confirmations[id]
+	if !ok || p.token != token || p.device != device || p.action != action || time.Now().After(p.expires) {
+		return false
+	}
+	delete(confirmations, id)
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var webCORSOrigins stringList
+
+func init() {
+	flag.Var(&webCORSOrigins, "web.cors-origin", "Origin, like https://wall.example.com, allowed to use /api/v1/, /stream and /ws from a browser; repeat it or separate with commas for several, * allows any")
+}
+
+// corsAllowed tells whether a page from origin may use the API, and whether
+// any origin may.
+func corsAllowed(origin string) (allowed, any bool) {
+	for _, o := range webCORSOrigins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+func corsPath(path string) bool {
+	return strings.HasPrefix(path, "/api/v1/") || path == "/stream"
+}
+
+// cors adds the CORS headers for the allowed origins to the API and stream
+// responses, and answers their preflight requests before authentication,
+// which browsers send without credentials.
+func cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(webCORSOrigins) == 0 || !corsPath(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed, any := corsAllowed(origin)
+		if origin == "" || !allowed {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// wsCheckOrigin lets pages from the exporter itself, and the allowed origins,
+// open a WebSocket.
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if allowed, _ := corsAllowed(origin); allowed {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
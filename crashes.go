@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CrashRateConfig notifies when a device crashes more than Count times within Window.
+type CrashRateConfig struct {
+	Count  int      `json:"count"`
+	Window duration `json:"window"`
+}
+
+const crashLogExcerpt = 1000
+
+var (
+	// guarded by mutex
+	lastCrashCount = map[string]int{}
+	crashTimes     = map[string][]time.Time{}
+	crashNotified  = map[string]time.Time{}
+)
+
+func init() {
+	onUpdate(trackCrashes)
+}
+
+func (c *CrashRateConfig) validate() error {
+	if c.Count <= 0 {
+		return errors.New("count is required")
+	}
+	if c.Window <= 0 {
+		c.Window = duration(time.Hour)
+	}
+	return nil
+}
+
+func trackCrashes(d *deviceState) {
+	if !d.Online {
+		return
+	}
+	now := time.Now()
+	count := d.Status.CrashCount
+	last, ok := lastCrashCount[d.Name]
+	lastCrashCount[d.Name] = count
+	// the count starts over when the crash log is cleared
+	if !ok || count <= last {
+		return
+	}
+	for i := last; i < count; i++ {
+		crashTimes[d.Name] = append(crashTimes[d.Name], now)
+	}
+
+	c := currentConfig().CrashRate
+	if c == nil {
+		return
+	}
+	window := time.Duration(c.Window)
+	times := crashTimes[d.Name]
+	for len(times) > 0 && now.Sub(times[0]) > window {
+		times = times[1:]
+	}
+	crashTimes[d.Name] = times
+	if len(times) <= c.Count || now.Sub(crashNotified[d.Name]) < window {
+		return
+	}
+	crashNotified[d.Name] = now
+
+	t, ok := findTarget(d.Name)
+	if !ok {
+		return
+	}
+	message := fmt.Sprintf("Device crashed %d times in %s", len(times), humanDuration(window))
+	title := displayName(d.Name)
+	go func() {
+		excerpt, err := fetchCrashLog(t)
+		if err != nil {
+			slog.Error("Error fetching crash log", "device", t.Name, "err", err)
+		} else if excerpt != "" {
+			message += "\n\n" + excerpt
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		notify(Notification{
+			Event:    "crash.rate",
+			Device:   t.Name,
+			Title:    title,
+			Message:  message,
+			Severity: severityWarning,
+			Time:     now,
+		})
+	}()
+}
+
+// fetchCrashLog returns the end of the device's crash log.
+func fetchCrashLog(t Target) (string, error) {
+	if t.hap() {
+		return "", nil
+	}
+	req, err := t.newRequest(context.Background(), http.MethodGet, t.endpoint("/crashlog"), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.do(controlClient, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > crashLogExcerpt {
+		text = "…" + text[len(text)-crashLogExcerpt:]
+	}
+	return text, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	ctlAddress string
+	ctlToken   string
+	ctlTLSCA   string
+	ctlTLSCert string
+	ctlTLSKey  string
+)
+
+func init() {
+	flag.StringVar(&ctlAddress, "ctl.address", os.Getenv("RATGDOCTL_ADDRESS"), "Address of the exporter for the ctl command (defaults to $RATGDOCTL_ADDRESS, or where -web.listen-address or -port would listen)")
+	flag.StringVar(&ctlToken, "ctl.token", os.Getenv("RATGDOCTL_TOKEN"), "API token for the ctl command (defaults to $RATGDOCTL_TOKEN)")
+	flag.StringVar(&ctlTLSCA, "ctl.tls-ca", os.Getenv("RATGDOCTL_TLS_CA"), "CA certificate to verify an HTTPS exporter with, for ctl and export (defaults to $RATGDOCTL_TLS_CA)")
+	flag.StringVar(&ctlTLSCert, "ctl.tls-cert", os.Getenv("RATGDOCTL_TLS_CERT"), "Client certificate for an exporter requiring one, for ctl and export (defaults to $RATGDOCTL_TLS_CERT)")
+	flag.StringVar(&ctlTLSKey, "ctl.tls-key", os.Getenv("RATGDOCTL_TLS_KEY"), "Private key file of -ctl.tls-cert (defaults to $RATGDOCTL_TLS_KEY)")
+}
+
+// apiClient returns the HTTP client for the API of a running exporter at
+// address and the URL to reach it with. Without an address that is the one
+// this exporter would listen on.
+func apiClient(address string) (*http.Client, *url.URL, error) {
+	if address == "" {
+		address = webAddresses()[0]
+		if !strings.HasPrefix(address, "unix://") {
+			host, p, _ := net.SplitHostPort(address)
+			if host == "" || host == "0.0.0.0" || host == "::" {
+				host = "localhost"
+			}
+			address = "http://" + net.JoinHostPort(host, p)
+		}
+	}
+	transport := &http.Transport{}
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		address = "http://localhost"
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ctlTLSCA != "" || ctlTLSCert != "" {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	if ctlTLSCA != "" {
+		pool, err := certPool(ctlTLSCA)
+		if err != nil {
+			return nil, nil, err
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if ctlTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(ctlTLSCert, ctlTLSKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}, u, nil
+}
+
+func ctlUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: ratgdoctl <command> [arguments]
+
+Commands:
+  status [device]                       Show the devices
+  events [-since 24h] [-device d] [-type t] [-limit n]
+                                        Show the latest events, newest first
+  door open|close|stop <device> [confirmation]
+                                        Operate the door
+  light on|off|toggle <device>          Switch the light
+  lock engage|disengage <device>        Lock out the wall console
+  maintenance on|pause|off <device>     Put the device into maintenance mode
+`)
+	os.Exit(2)
+}
+
+// ctlRequest calls the exporter's API and decodes the JSON response into v.
+func ctlRequest(method, path string, query url.Values, body interface{}, v interface{}) error {
+	client, u, err := apiClient(ctlAddress)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ctlToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ctlToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiError) == nil && apiError.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiError.Error)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// runCtl is the ratgdoctl client for the API of a running exporter.
+func runCtl(args []string) {
+	if len(args) == 0 {
+		ctlUsage()
+	}
+	var err error
+	switch args[0] {
+	case "status":
+		err = ctlStatus(args[1:])
+	case "events":
+		err = ctlEvents(args[1:])
+	case "door", "light", "lock", "maintenance":
+		if len(args) != 3 && (len(args) != 4 || args[0] != "door") {
+			ctlUsage()
+		}
+		confirmation := ""
+		if len(args) == 4 {
+			confirmation = args[3]
+		}
+		err = ctlControl(args[0], args[1], args[2], confirmation)
+	default:
+		ctlUsage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ratgdoctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func ctlStatus(args []string) error {
+	var snapshots []statusSnapshot
+	if len(args) > 0 {
+		var s statusSnapshot
+		if err := ctlRequest(http.MethodGet, "/api/v1/status/"+url.PathEscape(args[0]), nil, nil, &s); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, s)
+	} else if err := ctlRequest(http.MethodGet, "/api/v1/status", nil, nil, &snapshots); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tONLINE\tDOOR\tOPEN FOR\tLIGHT\tMOTION\tOBSTRUCTED\tLAST UPDATE")
+	for _, s := range snapshots {
+		openFor, updated := "-", "never"
+		if s.OpenDurationSeconds > 0 {
+			openFor = humanDuration(time.Duration(s.OpenDurationSeconds * float64(time.Second)))
+		}
+		if s.LastUpdate != nil {
+			updated = humanDuration(time.Since(*s.LastUpdate)) + " ago"
+		}
+		door := s.DoorState
+		if door == "" {
+			door = "-"
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%s\t%t\t%t\t%s\n", s.Device, s.Online, door, openFor, onOff(s.LightOn), s.Motion, s.Obstructed, updated)
+	}
+	return w.Flush()
+}
+
+func ctlEvents(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	since := fs.String("since", "24h", "Show events since this RFC 3339 time or duration ago")
+	device := fs.String("device", "", "Comma separated devices to show")
+	typ := fs.String("type", "", "Comma separated event types to show")
+	limit := fs.Int("limit", 0, "Show at most this many events")
+	fs.Parse(args)
+
+	query := url.Values{}
+	for k, v := range map[string]string{"since": *since, "device": *device, "type": *typ} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	if *limit > 0 {
+		query.Set("limit", fmt.Sprint(*limit))
+	}
+	var events []Event
+	if err := ctlRequest(http.MethodGet, "/api/v1/events", query, nil, &events); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tDEVICE\tEVENT\tFROM\tTO")
+	for _, e := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Device, e.Type, e.From, e.To)
+	}
+	return w.Flush()
+}
+
+func ctlControl(control, action, device, confirmation string) error {
+	var result map[string]string
+	path := "/api/v1/devices/" + url.PathEscape(device) + "/" + control
+	body := map[string]string{"action": action, "confirm": confirmation}
+	if err := ctlRequest(http.MethodPost, path, nil, body, &result); err != nil {
+		return err
+	}
+	if result["result"] == "confirm" {
+		expires, _ := time.Parse(time.RFC3339, result["expires"])
+		fmt.Printf("%s: confirm within %s with\n  ratgdoctl %s %s %s %s\n", device, humanDuration(time.Until(expires)), control, action, device, result["confirm"])
+		return nil
+	}
+	fmt.Printf("%s: %s %s %s\n", device, control, action, strings.ToLower(result["result"]))
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type jsonObject map[string]interface{}
+
+var fqNamePattern = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// metricName returns the name a collector was registered with, so the generated
+// dashboard always matches the metrics the exporter actually serves.
+func metricName(c prometheus.Collector) string {
+	ch := make(chan *prometheus.Desc, 1)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	name := ""
+	for desc := range ch {
+		if m := fqNamePattern.FindStringSubmatch(desc.String()); m != nil && name == "" {
+			name = m[1]
+		}
+	}
+	return name
+}
+
+// deviceSelector is the label matcher every device panel query uses.
+const deviceSelector = `{location=~"$location", deviceName=~"$deviceName"}`
+
+func dashboardPanel(id int, title, panelType string, x, y, w, h int, targets []jsonObject, extra jsonObject) jsonObject {
+	for i, t := range targets {
+		t["refId"] = string(rune('A' + i))
+		t["datasource"] = jsonObject{"type": "prometheus", "uid": "${datasource}"}
+	}
+	p := jsonObject{
+		"id":         id,
+		"title":      title,
+		"type":       panelType,
+		"datasource": jsonObject{"type": "prometheus", "uid": "${datasource}"},
+		"gridPos":    jsonObject{"x": x, "y": y, "w": w, "h": h},
+		"targets":    targets,
+	}
+	for k, v := range extra {
+		p[k] = v
+	}
+	return p
+}
+
+func query(expr, legend string) jsonObject {
+	return jsonObject{"expr": expr, "legendFormat": legend}
+}
+
+func stateMappings(mappings ...string) jsonObject {
+	options := jsonObject{}
+	for i := 0; i+2 < len(mappings); i += 3 {
+		options[mappings[i]] = jsonObject{"text": mappings[i+1], "color": mappings[i+2]}
+	}
+	return jsonObject{
+		"defaults": jsonObject{
+			"mappings": []jsonObject{{"type": "value", "options": options}},
+			"color":    jsonObject{"mode": "fixed"},
+		},
+	}
+}
+
+// generateDashboard is the dashboard with the names of the visible devices
+// preselected, or of all devices when visible is nil.
+func generateDashboard(visible map[string]bool) jsonObject {
+	names := map[string]bool{}
+	for _, d := range allDevices() {
+		if visible != nil && !visible[d.Name] {
+			continue
+		}
+		d.mu.Lock()
+		if d.Seen && d.Status.DeviceName != "" {
+			names[d.Status.DeviceName] = true
+		}
+		d.mu.Unlock()
+	}
+	var deviceNames []string
+	for name := range names {
+		deviceNames = append(deviceNames, name)
+	}
+	sort.Strings(deviceNames)
+
+	sel := func(c prometheus.Collector) string {
+		return metricName(c) + deviceSelector
+	}
+
+	panels := []jsonObject{
+		{
+			"id": 1, "type": "row", "title": "$deviceName", "collapsed": false,
+			"gridPos": jsonObject{"x": 0, "y": 0, "w": 24, "h": 1},
+			"repeat":  "deviceName", "panels": []jsonObject{},
+		},
+		dashboardPanel(2, "Door", "stat", 0, 1, 4, 5, []jsonObject{query(sel(garageDoorState), "{{deviceName}}")}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": stateMappings("0", "Closed", "green", "1", "Open", "orange")["defaults"]},
+			"options":     jsonObject{"colorMode": "background", "graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(3, "Open for", "stat", 4, 1, 4, 5, []jsonObject{query(sel(doorOpenSeconds), "{{deviceName}}")}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": jsonObject{"unit": "s"}},
+			"options":     jsonObject{"graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(4, "Light", "stat", 8, 1, 4, 5, []jsonObject{query(sel(garageLightOn), "{{deviceName}}")}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": stateMappings("0", "Off", "text", "1", "On", "yellow")["defaults"]},
+			"options":     jsonObject{"colorMode": "value", "graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(5, "Obstruction", "stat", 12, 1, 4, 5, []jsonObject{query(sel(garageObstructed), "{{deviceName}}")}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": stateMappings("0", "Clear", "green", "1", "Obstructed", "red")["defaults"]},
+			"options":     jsonObject{"colorMode": "background", "graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(6, "Uptime", "stat", 16, 1, 4, 5, []jsonObject{query(sel(upTime)+" / 1000", "{{deviceName}}")}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": jsonObject{"unit": "s"}},
+			"options":     jsonObject{"graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(7, "Door cycles (24h)", "stat", 20, 1, 4, 5, []jsonObject{query(fmt.Sprintf("increase(%s[24h])", sel(doorCycles)), "{{deviceName}}")}, jsonObject{
+			"options": jsonObject{"graphMode": "none", "reduceOptions": jsonObject{"calcs": []string{"lastNotNull"}}},
+		}),
+		dashboardPanel(8, "Door, light and motion", "state-timeline", 0, 6, 24, 6, []jsonObject{
+			query(sel(garageDoorState), "Door"),
+			query(sel(garageLightOn), "Light"),
+			query(sel(garageMotion), "Motion"),
+			query(sel(garageObstructed), "Obstruction"),
+		}, jsonObject{
+			"fieldConfig": jsonObject{"defaults": stateMappings("0", "Off", "transparent", "1", "On", "orange")["defaults"]},
+		}),
+		dashboardPanel(9, "Heap", "timeseries", 0, 12, 12, 8, []jsonObject{
+			query(sel(freeHeap), "Free"),
+			query(sel(minHeap), "Minimum"),
+		}, jsonObject{"fieldConfig": jsonObject{"defaults": jsonObject{"unit": "bytes"}}}),
+		dashboardPanel(10, "Minimum stack and crashes", "timeseries", 12, 12, 12, 8, []jsonObject{
+			query(sel(minStack), "Minimum stack"),
+			query(sel(crashCount), "Crashes"),
+		}, nil),
+	}
+
+	var current jsonObject
+	if len(deviceNames) > 0 {
+		current = jsonObject{"text": deviceNames, "value": deviceNames}
+	} else {
+		current = jsonObject{"text": "All", "value": "$__all"}
+	}
+
+	return jsonObject{
+		"title":         "homekit-ratgdo",
+		"uid":           "homekit-ratgdo",
+		"tags":          []string{"ratgdo", "garage"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          jsonObject{"from": "now-24h", "to": "now"},
+		"templating": jsonObject{"list": []jsonObject{
+			{"name": "datasource", "label": "Data source", "type": "datasource", "query": "prometheus"},
+			{
+				"name": "location", "label": "Location", "type": "query",
+				"datasource": jsonObject{"type": "prometheus", "uid": "${datasource}"},
+				"query":      fmt.Sprintf("label_values(%s, location)", metricName(garageDoorState)),
+				"refresh":    2, "includeAll": true, "multi": true,
+				"current": jsonObject{"text": "All", "value": "$__all"},
+			},
+			{
+				"name": "deviceName", "label": "Device", "type": "query",
+				"datasource": jsonObject{"type": "prometheus", "uid": "${datasource}"},
+				"query":      fmt.Sprintf(`label_values(%s{location=~"$location"}, deviceName)`, metricName(garageDoorState)),
+				"refresh":    2, "includeAll": true, "multi": true,
+				"current": current,
+			},
+		}},
+		"panels": panels,
+	}
+}
+
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, generateDashboard(visibleDevices(r)))
+}
+
+// runDashboard prints the dashboard, fetching the devices first so their names can be preselected.
+func runDashboard() {
+	collect(context.Background())
+	b, err := json.MarshalIndent(generateDashboard(nil), "", "  ")
+	if err != nil {
+		fatal("Error marshalling dashboard", "err", err)
+	}
+	os.Stdout.Write(append(b, '\n'))
+}
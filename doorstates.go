@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var doorStateSeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "homekit_ratgdo_door_state_seconds_total",
+	Help: "Seconds the garage door spent in each state (closed, opening, open, closing, stopped or unknown), between successful fetches.",
+}, []string{"location", "accessoryID", "deviceName", "displayName", "localIP", "macAddress", "state"})
+
+func init() {
+	prometheus.MustRegister(doorStateSeconds)
+}
+
+// addDoorStateTime counts the time since the last fetch for the state the
+// door was in then. Every state starts at 0, so rate() has all of them.
+func addDoorStateTime(labels []string, state string, elapsed time.Duration) {
+	if _, known := doorStates[state]; !known {
+		state = "Unknown"
+	}
+	for _, s := range []string{"Closed", "Opening", "Open", "Closing", "Stopped", "Unknown"} {
+		c := doorStateSeconds.WithLabelValues(append(labels, strings.ToLower(s))...)
+		if s == state {
+			c.Add(elapsed.Seconds())
+		}
+	}
+}
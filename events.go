@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	eventLogSize int
+	// recent events, oldest first; guarded by mutex
+	eventLog []Event
+)
+
+func init() {
+	flag.IntVar(&eventLogSize, "events.max", 1000, "Number of recent state transitions kept in memory for /api/v1/events without -storage.path")
+
+	onEvent(func(e Event) {
+		eventLog = append(eventLog, e)
+		if len(eventLog) > eventLogSize {
+			eventLog = eventLog[len(eventLog)-eventLogSize:]
+		}
+	})
+}
+
+// eventQuery selects events by device, type and time range.
+type eventQuery struct {
+	devices map[string]bool
+	types   map[string]bool
+	since   time.Time
+	until   time.Time
+	limit   int
+}
+
+func splitFilter(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	m := map[string]bool{}
+	for _, v := range strings.Split(s, ",") {
+		m[strings.TrimSpace(v)] = true
+	}
+	return m
+}
+
+// parseTime accepts an RFC 3339 timestamp or a duration meaning that long ago.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseEventQuery reads the query of the request, limited to the devices the
+// request may see.
+func parseEventQuery(r *http.Request) (eventQuery, error) {
+	values := r.URL.Query()
+	q := eventQuery{
+		devices: splitFilter(values.Get("device")),
+		types:   splitFilter(values.Get("type")),
+		limit:   -1,
+	}
+	var err error
+	if q.since, err = parseTime(values.Get("since")); err != nil {
+		return q, errors.New("invalid since")
+	}
+	if q.until, err = parseTime(values.Get("until")); err != nil {
+		return q, errors.New("invalid until")
+	}
+	if l := values.Get("limit"); l != "" {
+		if q.limit, err = strconv.Atoi(l); err != nil || q.limit < 0 {
+			return q, errors.New("invalid limit")
+		}
+	}
+	q.restrict(visibleDevices(r))
+	return q, nil
+}
+
+func (q eventQuery) matches(e Event) bool {
+	if q.devices != nil && !q.devices[e.Device] {
+		return false
+	}
+	if q.types != nil && !q.types[e.Type] {
+		return false
+	}
+	if !q.since.IsZero() && e.Time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && e.Time.After(q.until) {
+		return false
+	}
+	return true
+}
+
+// recentEvents returns the events matching q, newest first, from storage if
+// it is enabled and from memory otherwise.
+func recentEvents(q eventQuery) ([]Event, error) {
+	if db != nil {
+		return storedEvents(q)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	events := []Event{}
+	for i := len(eventLog) - 1; i >= 0 && (q.limit < 0 || len(events) < q.limit); i-- {
+		if q.matches(eventLog[i]) {
+			events = append(events, eventLog[i])
+		}
+	}
+	return events, nil
+}
+
+// eventsHandler serves /api/v1/events, newest first, optionally filtered by
+// ?device=a,b, ?type=door,light, ?since= and ?until= and limited with ?limit=n.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q, err := parseEventQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	events, err := recentEvents(q)
+	if err != nil {
+		slog.Error("Error reading events", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "error reading events")
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// eventsCSVHandler serves the same events as /api/v1/events as CSV, oldest first.
+func eventsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := recentEvents(q)
+	if err != nil {
+		slog.Error("Error reading events", "err", err)
+		http.Error(w, "Error reading events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="events.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "device", "type", "from", "to"})
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		cw.Write([]string{e.Time.Format(time.RFC3339), e.Device, e.Type, e.From, e.To})
+	}
+	cw.Flush()
+}
+
+// dailyEventsHandler serves /api/v1/events/daily, the number of transitions per
+// device and day from storage, filtered like /api/v1/events.
+func dailyEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if db == nil {
+		writeJSONError(w, http.StatusNotFound, "daily counts need -storage.path")
+		return
+	}
+	q, err := parseEventQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	counts, err := dailyCounts(q)
+	if err != nil {
+		slog.Error("Error reading daily counts", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "error reading daily counts")
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var (
+	exportAddress string
+	exportSince   string
+	exportUntil   string
+	exportDevice  string
+	exportType    string
+	exportToken   string
+)
+
+func init() {
+	flag.StringVar(&exportAddress, "export.address", "", "Address of the running exporter to export events from (defaults to where -web.listen-address or -port would listen)")
+	flag.StringVar(&exportSince, "export.since", "24h", "Export events since this RFC 3339 time or duration ago")
+	flag.StringVar(&exportUntil, "export.until", "", "Export events until this RFC 3339 time or duration ago")
+	flag.StringVar(&exportDevice, "export.device", "", "Comma separated devices to export (all when empty)")
+	flag.StringVar(&exportType, "export.type", "door", "Comma separated event types to export (all when empty)")
+	flag.StringVar(&exportToken, "export.token", "", "API token with the read scope, if the exporter has protectRead set")
+}
+
+// runExport writes the events of a running exporter to stdout as CSV.
+func runExport() {
+	client, u, err := apiClient(exportAddress)
+	if err != nil {
+		fatal("Error connecting to the exporter", "err", err)
+	}
+	u.Path = "/api/v1/events.csv"
+	query := url.Values{}
+	for k, v := range map[string]string{"since": exportSince, "until": exportUntil, "device": exportDevice, "type": exportType} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		fatal("Error fetching events", "err", err)
+	}
+	if exportToken != "" {
+		req.Header.Set("Authorization", "Bearer "+exportToken)
+	}
+	// an export can take a while
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		fatal("Error fetching events", "err", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		fatal("Error fetching events", "status", resp.Status, "body", string(body))
+	}
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		fatal("Error writing events", "err", err)
+	}
+}
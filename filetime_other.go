@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+func birthTime(path string) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	firmwareCheckInterval time.Duration
+	firmwareReleaseURL    string
+
+	firmwareOutdated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_firmware_outdated",
+		Help: "Whether the device runs an older firmware than the latest homekit-ratgdo release.",
+	}, deviceLabelNames)
+
+	firmwareLatest = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_firmware_latest_info",
+		Help: "The latest homekit-ratgdo firmware release.",
+	}, []string{"version"})
+
+	// guarded by mutex
+	latestFirmware   string
+	firmwareNotified = map[string]string{}
+)
+
+func init() {
+	flag.DurationVar(&firmwareCheckInterval, "firmware.check-interval", 0, "Check for new homekit-ratgdo firmware releases at this interval (0 disables the check)")
+	flag.StringVar(&firmwareReleaseURL, "firmware.release-url", "https://api.github.com/repos/ratgdo/homekit-ratgdo/releases/latest", "GitHub API URL of the latest firmware release")
+
+	prometheus.MustRegister(firmwareOutdated)
+	prometheus.MustRegister(firmwareLatest)
+	onUpdate(checkFirmware)
+}
+
+// compareVersions compares dotted versions like v1.9.0 numerically, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	split := func(v string) []string {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' })
+	}
+	pa, pb := split(a), split(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		nx, errx := strconv.Atoi(x)
+		ny, erry := strconv.Atoi(y)
+		switch {
+		case errx == nil && erry == nil && nx != ny:
+			if nx < ny {
+				return -1
+			}
+			return 1
+		case (errx != nil || erry != nil) && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func fetchLatestFirmware() (string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, firmwareReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("release has no tag")
+	}
+	return release.TagName, nil
+}
+
+// checkFirmware compares the device's firmware with the latest release; it runs
+// with the device's mu and mutex held.
+func checkFirmware(d *deviceState) {
+	if latestFirmware == "" || !d.Seen || d.Status.FirmwareVersion == "" {
+		return
+	}
+	s := d.Status
+	outdated := compareVersions(s.FirmwareVersion, latestFirmware) < 0
+	firmwareOutdated.WithLabelValues(deviceLabels(d.Name, s)...).Set(boolToFloat(outdated))
+	if !outdated || firmwareNotified[d.Name] == latestFirmware {
+		return
+	}
+	firmwareNotified[d.Name] = latestFirmware
+	notify(Notification{
+		Event:    "firmware.update",
+		Device:   d.Name,
+		Title:    displayName(d.Name),
+		Message:  fmt.Sprintf("Firmware %s is available, the device runs %s", latestFirmware, s.FirmwareVersion),
+		Severity: severityInfo,
+		Time:     time.Now(),
+	})
+}
+
+func watchFirmware() {
+	defer reportPanic()
+	for {
+		latest, err := fetchLatestFirmware()
+		if err != nil {
+			slog.Error("Error checking for firmware updates", "url", firmwareReleaseURL, "err", err)
+		} else {
+			mutex.Lock()
+			if latest != latestFirmware {
+				slog.Info("Found latest homekit-ratgdo firmware", "version", latest)
+				firmwareLatest.Reset()
+				firmwareLatest.WithLabelValues(latest).Set(1)
+				latestFirmware = latest
+			}
+			mutex.Unlock()
+			for _, d := range allDevices() {
+				d.mu.Lock()
+				mutex.Lock()
+				checkFirmware(d)
+				mutex.Unlock()
+				d.mu.Unlock()
+			}
+		}
+		time.Sleep(firmwareCheckInterval)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.9.0", "v1.9.0", 0},
+		{"1.9.0", "v1.9.0", 0},
+		{" v1.9.0", "v1.9.0 ", 0},
+		{"v1.9.0", "v1.10.0", -1},
+		{"v2.0.0", "v1.10.9", 1},
+		{"v1.9", "v1.9.0", -1},
+		{"v1.9.1", "v1.9", 1},
+		{"v1.9.0-beta", "v1.9.0-rc", -1},
+		{"", "v1.0.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
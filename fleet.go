@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fleetCollector exposes aggregates over all devices, for "is everything
+// closed?" panels without PromQL, and the same per group of devices.
+type fleetCollector struct {
+	devices     *prometheus.Desc
+	doorsOpen   *prometheus.Desc
+	anyOpen     *prometheus.Desc
+	anyObstruct *prometheus.Desc
+	unreachable *prometheus.Desc
+	targets     *prometheus.Desc
+
+	deviceGroup      *prometheus.Desc
+	groupDevices     *prometheus.Desc
+	groupDoorsOpen   *prometheus.Desc
+	groupAnyOpen     *prometheus.Desc
+	groupAnyObstruct *prometheus.Desc
+	groupUnreachable *prometheus.Desc
+}
+
+// fleetCounts is the tally of some devices.
+type fleetCounts struct {
+	devices, open, obstructed, unreachable int
+}
+
+func init() {
+	labels := []string{"location"}
+	groupLabels := []string{"location", "group"}
+	prometheus.MustRegister(fleetCollector{
+		devices:     prometheus.NewDesc("homekit_ratgdo_devices", "Number of devices the exporter watches.", labels, nil),
+		doorsOpen:   prometheus.NewDesc("homekit_ratgdo_doors_open", "Number of doors that aren't closed.", labels, nil),
+		anyOpen:     prometheus.NewDesc("homekit_ratgdo_any_door_open", "1 if any door isn't closed.", labels, nil),
+		anyObstruct: prometheus.NewDesc("homekit_ratgdo_any_obstruction", "1 if any door is obstructed.", labels, nil),
+		unreachable: prometheus.NewDesc("homekit_ratgdo_devices_unreachable", "Number of devices whose last fetch failed.", labels, nil),
+		targets:     prometheus.NewDesc("homekit_ratgdo_targets", "Number of targets by state: configured (all this exporter watches, from the flags, config and discovery, in its shard with -shard.total), reachable, failing or disabled (paused for maintenance).", []string{"location", "state"}, nil),
+
+		deviceGroup:      prometheus.NewDesc("homekit_ratgdo_device_group", "Always 1, labeled with the group of the device, to join with.", []string{"location", "device", "accessoryID", "group"}, nil),
+		groupDevices:     prometheus.NewDesc("homekit_ratgdo_group_devices", "Number of devices in the group.", groupLabels, nil),
+		groupDoorsOpen:   prometheus.NewDesc("homekit_ratgdo_group_doors_open", "Number of doors in the group that aren't closed.", groupLabels, nil),
+		groupAnyOpen:     prometheus.NewDesc("homekit_ratgdo_group_any_door_open", "1 if any door in the group isn't closed.", groupLabels, nil),
+		groupAnyObstruct: prometheus.NewDesc("homekit_ratgdo_group_any_obstruction", "1 if any door in the group is obstructed.", groupLabels, nil),
+		groupUnreachable: prometheus.NewDesc("homekit_ratgdo_group_devices_unreachable", "Number of devices in the group whose last fetch failed.", groupLabels, nil),
+	})
+}
+
+func (c fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.devices
+	ch <- c.doorsOpen
+	ch <- c.anyOpen
+	ch <- c.anyObstruct
+	ch <- c.unreachable
+	ch <- c.targets
+	ch <- c.deviceGroup
+	ch <- c.groupDevices
+	ch <- c.groupDoorsOpen
+	ch <- c.groupAnyOpen
+	ch <- c.groupAnyObstruct
+	ch <- c.groupUnreachable
+}
+
+func (c fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	groups := currentConfig().groups
+
+	// devices can have their own location, the aggregates are per location
+	type groupKey struct{ location, group string }
+	devices := allDevices()
+	byLocation := map[string]*fleetCounts{}
+	if len(devices) == 0 {
+		byLocation[location] = &fleetCounts{}
+	}
+	byGroup := map[groupKey]*fleetCounts{}
+	for name, d := range devices {
+		loc := targetLocation(name)
+		if byLocation[loc] == nil {
+			byLocation[loc] = &fleetCounts{}
+		}
+		group, grouped := groups[name]
+		key := groupKey{loc, group}
+		if grouped && byGroup[key] == nil {
+			byGroup[key] = &fleetCounts{}
+		}
+		d.mu.Lock()
+		accessoryID := d.Status.AccessoryID
+		for _, counts := range []*fleetCounts{byLocation[loc], byGroup[key]} {
+			if counts == nil {
+				continue
+			}
+			counts.devices++
+			// what an unreachable device reported last may be outdated, but it's the best guess
+			if d.Seen && doorStates[d.Status.GarageDoorState] == 1 {
+				counts.open++
+			}
+			if d.Seen && d.Status.GarageObstructed {
+				counts.obstructed++
+			}
+			if !d.LastFetch.IsZero() && !d.Online {
+				counts.unreachable++
+			}
+		}
+		d.mu.Unlock()
+		if grouped {
+			ch <- prometheus.MustNewConstMetric(c.deviceGroup, prometheus.GaugeValue, 1, loc, name, accessoryID, group)
+		}
+	}
+
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	for loc, counts := range byLocation {
+		gauge(c.devices, float64(counts.devices), loc)
+		gauge(c.doorsOpen, float64(counts.open), loc)
+		gauge(c.anyOpen, boolToFloat(counts.open > 0), loc)
+		gauge(c.anyObstruct, boolToFloat(counts.obstructed > 0), loc)
+		gauge(c.unreachable, float64(counts.unreachable), loc)
+	}
+	// by location, every state even when none of the targets is in it
+	states := map[string]map[string]int{}
+	statesOf := func(loc string) map[string]int {
+		if states[loc] == nil {
+			states[loc] = map[string]int{"configured": 0, "reachable": 0, "failing": 0, "disabled": 0}
+		}
+		return states[loc]
+	}
+	for loc := range byLocation {
+		statesOf(loc)
+	}
+	for _, t := range currentTargets() {
+		byState := statesOf(targetLocation(t.Name))
+		byState["configured"]++
+		if maintenanceMode(t.Name) == maintenancePause {
+			byState["disabled"]++
+			continue
+		}
+		d, ok := devices[t.Name]
+		if !ok {
+			continue
+		}
+		d.mu.Lock()
+		switch {
+		case d.Online:
+			byState["reachable"]++
+		case !d.LastFetch.IsZero():
+			byState["failing"]++
+		}
+		d.mu.Unlock()
+	}
+	for loc, byState := range states {
+		for state, n := range byState {
+			gauge(c.targets, float64(n), loc, state)
+		}
+	}
+	for key, counts := range byGroup {
+		gauge(c.groupDevices, float64(counts.devices), key.location, key.group)
+		gauge(c.groupDoorsOpen, float64(counts.open), key.location, key.group)
+		gauge(c.groupAnyOpen, boolToFloat(counts.open > 0), key.location, key.group)
+		gauge(c.groupAnyObstruct, boolToFloat(counts.obstructed > 0), key.location, key.group)
+		gauge(c.groupUnreachable, float64(counts.unreachable), key.location, key.group)
+	}
+}
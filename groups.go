@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// validateGroups checks every device is in at most one group, and that the
+// alerts are scoped to groups that exist.
+func (c *Config) validateGroups() error {
+	c.groups = map[string]string{}
+	var names []string
+	for group := range c.Groups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	for _, group := range names {
+		members := c.Groups[group]
+		if group == "" {
+			return fmt.Errorf("group name is required")
+		}
+		for _, d := range members {
+			if other, ok := c.groups[d]; ok && other != group {
+				return fmt.Errorf("group %s: device %s is in group %s already", group, d, other)
+			}
+			c.groups[d] = group
+		}
+	}
+	for _, a := range c.Alerts {
+		for _, g := range a.Groups {
+			if _, ok := c.Groups[g]; !ok {
+				return fmt.Errorf("alert %s: unknown group %s", a.Name, g)
+			}
+		}
+	}
+	return nil
+}
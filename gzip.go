@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var fetchedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "homekit_ratgdo_fetched_bytes_total",
+	Help: "Bytes of device responses as they came over the network, labeled by device and encoding (gzip or identity).",
+}, []string{"device", "encoding"})
+
+func init() {
+	prometheus.MustRegister(fetchedBytes)
+}
+
+// readDeviceBody reads the body of a device's response, decompressing it if
+// it came gzipped. The request asks for gzip itself, so net/http leaves the
+// body as it came and the bytes on the air can be counted.
+func readDeviceBody(device string, resp *http.Response) ([]byte, error) {
+	wire := &countingReader{r: resp.Body}
+	var r io.Reader = wire
+	encoding := "identity"
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		encoding = "gzip"
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	body, err := ioutil.ReadAll(r)
+	fetchedBytes.WithLabelValues(device, encoding).Add(float64(wire.n))
+	return body, err
+}
+
+// gzipResponseWriter compresses what the handler writes, once it is known
+// there is a body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusNoContent && code != http.StatusNotModified {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// net/http would sniff the compressed bytes
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// acceptsGzip tells whether the client listed gzip in Accept-Encoding, without q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != "gzip" {
+			continue
+		}
+		if len(fields) > 1 && strings.ReplaceAll(fields[1], " ", "") == "q=0" {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// compress gzips the responses of h for clients that accept it. /metrics
+// doesn't need it, promhttp negotiates compression itself.
+func compress(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		h(gw, r)
+		if gw.gz != nil {
+			gw.gz.Close()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lease is the content of -ha.lease-file.
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	haLeaseFile     string
+	haLeaseDuration time.Duration
+	haIdentity      string
+
+	// always true without -ha.lease-file
+	leader atomic.Bool
+
+	leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_exporter_leader",
+		Help: "1 if this exporter holds the -ha.lease-file lease and polls the devices, 0 on standby.",
+	})
+)
+
+func init() {
+	hostname, _ := os.Hostname()
+	flag.StringVar(&haLeaseFile, "ha.lease-file", "", "Lease file on storage shared with a standby exporter; only the exporter holding the lease fetches the devices and sends notifications")
+	flag.DurationVar(&haLeaseDuration, "ha.lease-duration", 15*time.Second, "How long the lease lasts without being renewed, before a standby takes over")
+	flag.StringVar(&haIdentity, "ha.identity", fmt.Sprintf("%s/%d", hostname, os.Getpid()), "Name of this exporter in the lease file")
+
+	leader.Store(true)
+	prometheus.MustRegister(leaderGauge)
+}
+
+func isLeader() bool {
+	return leader.Load()
+}
+
+func readLease() (lease, error) {
+	var l lease
+	b, err := os.ReadFile(haLeaseFile)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return l, err
+	}
+	// a broken lease is as good as none
+	json.Unmarshal(b, &l)
+	return l, nil
+}
+
+// writeLease replaces the lease file in one step, so the other exporter never reads half of it.
+func writeLease(l lease) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", haLeaseFile, os.Getpid())
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, haLeaseFile)
+}
+
+// holdLease takes the lease when it is free or expired and renews it while
+// held. A new holder only counts after it still holds the lease a moment
+// later, in case the standby took it at the same time.
+func holdLease() (bool, error) {
+	l, err := readLease()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if l.Holder != haIdentity && now.Before(l.Expires) {
+		return false, nil
+	}
+	if err := writeLease(lease{Holder: haIdentity, Expires: now.Add(haLeaseDuration)}); err != nil {
+		return false, err
+	}
+	if l.Holder != haIdentity {
+		time.Sleep(time.Second)
+		if l, err = readLease(); err != nil || l.Holder != haIdentity {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// electLeader competes for the lease for as long as the exporter runs.
+func electLeader() {
+	defer reportPanic()
+	for {
+		held, err := holdLease()
+		if err != nil {
+			slog.Error("Error renewing lease", "file", haLeaseFile, "err", err)
+		}
+		if held != isLeader() {
+			if held {
+				slog.Info("Became the leader, polling the devices", "identity", haIdentity)
+			} else {
+				slog.Warn("Standing by, another exporter holds the lease", "identity", haIdentity)
+			}
+			leader.Store(held)
+			leaderGauge.Set(boolToFloat(held))
+		}
+		time.Sleep(haLeaseDuration / 3)
+	}
+}
+
+// releaseLease lets the standby take over right away when the leader stops.
+func releaseLease() {
+	if haLeaseFile == "" || !isLeader() {
+		return
+	}
+	leader.Store(false)
+	if l, err := readLease(); err == nil && l.Holder == haIdentity {
+		if err := writeLease(lease{Holder: haIdentity}); err != nil {
+			slog.Error("Error releasing lease", "file", haLeaseFile, "err", err)
+		}
+	}
+}
+
+// startHA starts on standby and competes for the lease when -ha.lease-file is set.
+func startHA() error {
+	if haLeaseFile == "" {
+		leaderGauge.Set(1)
+		return nil
+	}
+	if haLeaseDuration < 3*time.Second {
+		return fmt.Errorf("-ha.lease-duration has to be at least 3s")
+	}
+	leader.Store(false)
+	slog.Info("Standing by until this exporter holds the lease", "file", haLeaseFile, "identity", haIdentity)
+	go electLeader()
+	return nil
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Devices with a hap://host:port address are read over the HomeKit Accessory
+// Protocol instead of their web UI, like the Home app does. The exporter has
+// to be paired with them once, with the pair command and the setup code.
+
+// TLV8 types of the pairing messages.
+const (
+	tlvMethod        byte = 0x00
+	tlvIdentifier    byte = 0x01
+	tlvSalt          byte = 0x02
+	tlvPublicKey     byte = 0x03
+	tlvProof         byte = 0x04
+	tlvEncryptedData byte = 0x05
+	tlvState         byte = 0x06
+	tlvError         byte = 0x07
+	tlvSignature     byte = 0x0a
+)
+
+// hapN is the 3072 bit group of RFC 5054 that HomeKit uses for SRP, with the generator 5.
+var hapN, _ = new(big.Int).SetString(strings.Join([]string{
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74",
+	"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437",
+	"4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED",
+	"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05",
+	"98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB",
+	"9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B",
+	"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718",
+	"3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33",
+	"A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7",
+	"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864",
+	"D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2",
+	"08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF",
+}, ""), 16)
+
+var hapG = big.NewInt(5)
+
+// srpGroup is the client side of SRP-6a as in RFC 5054, with the group of the
+// prime n and generator g and its hash function.
+type srpGroup struct {
+	n, g *big.Int
+	hash func(parts ...[]byte) []byte
+}
+
+// hapSRP is SRP the way HomeKit does it, with the 3072 bit group and SHA-512.
+var hapSRP = srpGroup{n: hapN, g: hapG, hash: hapHash}
+
+func (s srpGroup) int(parts ...[]byte) *big.Int {
+	return new(big.Int).SetBytes(s.hash(parts...))
+}
+
+// pad is n as big endian bytes as long as the prime.
+func (s srpGroup) pad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, len(s.n.Bytes())))
+}
+
+// k is the multiplier parameter, H(N | PAD(g)).
+func (s srpGroup) k() *big.Int {
+	return s.int(s.n.Bytes(), s.pad(s.g))
+}
+
+// x is the private key derived from the password, H(salt | H(user | ":" | password)).
+func (s srpGroup) x(salt []byte, user, password string) *big.Int {
+	return s.int(salt, s.hash([]byte(user+":"+password)))
+}
+
+// u is the scrambling parameter, H(PAD(A) | PAD(B)).
+func (s srpGroup) u(public, serverPublic *big.Int) *big.Int {
+	return s.int(s.pad(public), s.pad(serverPublic))
+}
+
+// public is the client's public key A = g^a for its secret a.
+func (s srpGroup) public(a *big.Int) *big.Int {
+	return new(big.Int).Exp(s.g, a, s.n)
+}
+
+// premaster is the secret S = (B - k * g^x) ^ (a + u * x) the client shares
+// with the server.
+func (s srpGroup) premaster(a, serverPublic *big.Int, salt []byte, user, password string) *big.Int {
+	x := s.x(salt, user, password)
+	base := new(big.Int).Sub(serverPublic, new(big.Int).Mul(s.k(), new(big.Int).Exp(s.g, x, s.n)))
+	base.Mod(base, s.n)
+	exponent := new(big.Int).Add(a, new(big.Int).Mul(s.u(s.public(a), serverPublic), x))
+	return new(big.Int).Exp(base, exponent, s.n)
+}
+
+// hapPairings are the exporter's HomeKit identity and the devices it is paired with.
+type hapPairings struct {
+	ControllerID string `json:"controllerID"`
+	// the seed of the controller's Ed25519 key
+	ControllerKey []byte `json:"controllerKey"`
+	// by device name
+	Accessories map[string]hapAccessory `json:"accessories"`
+}
+
+type hapAccessory struct {
+	ID        string `json:"id"`
+	PublicKey []byte `json:"publicKey"`
+}
+
+// hapSession is an encrypted connection to a paired device.
+type hapSession struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// hapDevice is a device's session, open while it isn't nil. mu is held while
+// it is used, so a slow device only holds up its own fetches.
+type hapDevice struct {
+	mu      sync.Mutex
+	session *hapSession
+}
+
+var (
+	hapPairingsFile string
+
+	hapMutex sync.Mutex
+	// read from -hap.pairings on first use; guarded by hapMutex
+	hapPaired *hapPairings
+	// by device name; guarded by hapMutex
+	hapDevices = map[string]*hapDevice{}
+)
+
+func init() {
+	flag.StringVar(&hapPairingsFile, "hap.pairings", "hap-pairings.json", "File the exporter's HomeKit pairings with hap:// devices are kept in, written by the pair command")
+}
+
+// hap reports whether the target is read over HomeKit.
+func (t Target) hap() bool {
+	return strings.HasPrefix(t.URL, "hap://")
+}
+
+func loadHAPPairings() (*hapPairings, error) {
+	p := &hapPairings{Accessories: map[string]hapAccessory{}}
+	b, err := os.ReadFile(hapPairingsFile)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", hapPairingsFile, err)
+	}
+	if p.Accessories == nil {
+		p.Accessories = map[string]hapAccessory{}
+	}
+	return p, nil
+}
+
+func (p *hapPairings) save() error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(hapPairingsFile), "."+filepath.Base(hapPairingsFile)+".tmp")
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, hapPairingsFile)
+}
+
+func (p *hapPairings) controllerKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(p.ControllerKey)
+}
+
+func hapTLV(items ...interface{}) []byte {
+	var b bytes.Buffer
+	for i := 0; i+1 < len(items); i += 2 {
+		typ := items[i].(byte)
+		var value []byte
+		switch v := items[i+1].(type) {
+		case byte:
+			value = []byte{v}
+		case []byte:
+			value = v
+		case string:
+			value = []byte(v)
+		}
+		// longer values are split into fragments of the same type
+		for first := true; first || len(value) > 0; first = false {
+			n := min(len(value), 255)
+			b.WriteByte(typ)
+			b.WriteByte(byte(n))
+			b.Write(value[:n])
+			value = value[n:]
+		}
+	}
+	return b.Bytes()
+}
+
+func parseHAPTLV(b []byte) (map[byte][]byte, error) {
+	items := map[byte][]byte{}
+	last := -1
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, errors.New("truncated TLV")
+		}
+		typ, value := b[0], b[2:2+int(b[1])]
+		if int(typ) == last {
+			items[typ] = append(items[typ], value...)
+		} else {
+			items[typ] = append([]byte{}, value...)
+		}
+		last = int(typ)
+		b = b[2+int(b[1]):]
+	}
+	if e, ok := items[tlvError]; ok && len(e) > 0 {
+		return nil, hapError(e[0])
+	}
+	return items, nil
+}
+
+type hapError byte
+
+func (e hapError) Error() string {
+	switch e {
+	case 2:
+		return "the setup code is wrong"
+	case 3:
+		return "too many attempts, reset the device's pairing"
+	case 4:
+		return "the device is paired with too many controllers"
+	case 6:
+		return "the device is already paired, unpair it in the Home app or reset its pairing first"
+	case 7:
+		return "the device is busy pairing with someone else"
+	}
+	return fmt.Sprintf("HomeKit error %d", byte(e))
+}
+
+func hkdfKey(secret []byte, salt, info string) []byte {
+	key := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha512.New, secret, []byte(salt), []byte(info)), key)
+	return key
+}
+
+// hapNonce is the 12 byte nonce of a pairing message, like PS-Msg05.
+func hapNonce(s string) []byte {
+	return append(make([]byte, 4), s...)
+}
+
+func hapSeal(key []byte, nonce string, plaintext []byte) []byte {
+	aead, _ := chacha20poly1305.New(key)
+	return aead.Seal(nil, hapNonce(nonce), plaintext, nil)
+}
+
+func hapOpen(key []byte, nonce string, ciphertext []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(key)
+	return aead.Open(nil, hapNonce(nonce), ciphertext, nil)
+}
+
+func hapHash(parts ...[]byte) []byte {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// hapPost posts a pairing message and returns the decoded response.
+func hapPost(conn io.Writer, reader *bufio.Reader, host, path string, body []byte) (map[byte][]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, "http://"+host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/pairing+tlv8")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, path)
+	}
+	return parseHAPTLV(b)
+}
+
+// hapPair pairs the exporter with the device at address with its setup code,
+// like XXX-XX-XXX, and returns what it needs to connect to it from now on.
+func hapPair(address, setupCode string, p *hapPairings) (hapAccessory, error) {
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return hapAccessory{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	reader := bufio.NewReader(conn)
+
+	// M1 and M2: SRP start
+	m2, err := hapPost(conn, reader, address, "/pair-setup", hapTLV(tlvState, byte(1), tlvMethod, byte(0)))
+	if err != nil {
+		return hapAccessory{}, err
+	}
+	salt, serverPublic := m2[tlvSalt], new(big.Int).SetBytes(m2[tlvPublicKey])
+	if len(salt) == 0 || serverPublic.Sign() == 0 || new(big.Int).Mod(serverPublic, hapN).Sign() == 0 {
+		return hapAccessory{}, errors.New("invalid SRP parameters from the device")
+	}
+
+	// M3 and M4: SRP verify
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	a := new(big.Int).SetBytes(secret)
+	public := hapSRP.public(a)
+	shared := hapSRP.premaster(a, serverPublic, salt, "Pair-Setup", setupCode)
+	sessionKey := hapHash(hapSRP.pad(shared))
+	hashN, hashG := hapHash(hapN.Bytes()), hapHash(hapG.Bytes())
+	for i := range hashN {
+		hashN[i] ^= hashG[i]
+	}
+	proof := hapHash(hashN, hapHash([]byte("Pair-Setup")), salt, hapSRP.pad(public), hapSRP.pad(serverPublic), sessionKey)
+	m4, err := hapPost(conn, reader, address, "/pair-setup", hapTLV(tlvState, byte(3), tlvPublicKey, hapSRP.pad(public), tlvProof, proof))
+	if err != nil {
+		return hapAccessory{}, err
+	}
+	if !bytes.Equal(m4[tlvProof], hapHash(hapSRP.pad(public), proof, sessionKey)) {
+		return hapAccessory{}, errors.New("the device's SRP proof is wrong")
+	}
+
+	// M5 and M6: exchange the long-term keys
+	key := p.controllerKey()
+	encryptKey := hkdfKey(sessionKey, "Pair-Setup-Encrypt-Salt", "Pair-Setup-Encrypt-Info")
+	controllerX := hkdfKey(sessionKey, "Pair-Setup-Controller-Sign-Salt", "Pair-Setup-Controller-Sign-Info")
+	controllerPublic := key.Public().(ed25519.PublicKey)
+	signature := ed25519.Sign(key, append(append(controllerX, p.ControllerID...), controllerPublic...))
+	sub := hapTLV(tlvIdentifier, p.ControllerID, tlvPublicKey, []byte(controllerPublic), tlvSignature, signature)
+	m6, err := hapPost(conn, reader, address, "/pair-setup", hapTLV(tlvState, byte(5), tlvEncryptedData, hapSeal(encryptKey, "PS-Msg05", sub)))
+	if err != nil {
+		return hapAccessory{}, err
+	}
+	plain, err := hapOpen(encryptKey, "PS-Msg06", m6[tlvEncryptedData])
+	if err != nil {
+		return hapAccessory{}, errors.New("can't decrypt the device's keys")
+	}
+	info, err := parseHAPTLV(plain)
+	if err != nil {
+		return hapAccessory{}, err
+	}
+	accessory := hapAccessory{ID: string(info[tlvIdentifier]), PublicKey: info[tlvPublicKey]}
+	accessoryX := hkdfKey(sessionKey, "Pair-Setup-Accessory-Sign-Salt", "Pair-Setup-Accessory-Sign-Info")
+	signed := append(append(accessoryX, accessory.ID...), accessory.PublicKey...)
+	if len(accessory.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(accessory.PublicKey, signed, info[tlvSignature]) {
+		return hapAccessory{}, errors.New("the device's signature is wrong")
+	}
+	return accessory, nil
+}
+
+// hapConnect opens an encrypted session with a paired device.
+func hapConnect(ctx context.Context, address string, p *hapPairings, accessory hapAccessory) (*hapSession, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	session, err := hapVerify(conn, address, p, accessory)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// hapVerify proves to the device that it is paired with the exporter, and
+// sets up the session keys.
+func hapVerify(conn net.Conn, address string, p *hapPairings, accessory hapAccessory) (*hapSession, error) {
+	reader := bufio.NewReader(conn)
+	private := make([]byte, curve25519.ScalarSize)
+	rand.Read(private)
+	public, _ := curve25519.X25519(private, curve25519.Basepoint)
+
+	m2, err := hapPost(conn, reader, address, "/pair-verify", hapTLV(tlvState, byte(1), tlvPublicKey, public))
+	if err != nil {
+		return nil, err
+	}
+	accessoryPublic := m2[tlvPublicKey]
+	shared, err := curve25519.X25519(private, accessoryPublic)
+	if err != nil {
+		return nil, err
+	}
+	encryptKey := hkdfKey(shared, "Pair-Verify-Encrypt-Salt", "Pair-Verify-Encrypt-Info")
+	plain, err := hapOpen(encryptKey, "PV-Msg02", m2[tlvEncryptedData])
+	if err != nil {
+		return nil, errors.New("can't decrypt the device's proof")
+	}
+	info, err := parseHAPTLV(plain)
+	if err != nil {
+		return nil, err
+	}
+	signed := append(append(append([]byte{}, accessoryPublic...), info[tlvIdentifier]...), public...)
+	if string(info[tlvIdentifier]) != accessory.ID || !ed25519.Verify(accessory.PublicKey, signed, info[tlvSignature]) {
+		return nil, errors.New("the device isn't the one the exporter was paired with")
+	}
+
+	signature := ed25519.Sign(p.controllerKey(), append(append(append([]byte{}, public...), p.ControllerID...), accessoryPublic...))
+	sub := hapTLV(tlvIdentifier, p.ControllerID, tlvSignature, signature)
+	if _, err := hapPost(conn, reader, address, "/pair-verify", hapTLV(tlvState, byte(3), tlvEncryptedData, hapSeal(encryptKey, "PV-Msg03", sub))); err != nil {
+		return nil, err
+	}
+
+	c := &hapConn{
+		Conn:     conn,
+		readKey:  hkdfKey(shared, "Control-Salt", "Control-Read-Encryption-Key"),
+		writeKey: hkdfKey(shared, "Control-Salt", "Control-Write-Encryption-Key"),
+	}
+	return &hapSession{conn: c, reader: bufio.NewReader(c)}, nil
+}
+
+// hapConn encrypts a session in frames of at most 1024 bytes.
+type hapConn struct {
+	net.Conn
+	readKey, writeKey     []byte
+	readCount, writeCount uint64
+	pending               []byte
+}
+
+func (c *hapConn) nonce(count uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.LittleEndian.PutUint64(nonce[4:], count)
+	return nonce
+}
+
+func (c *hapConn) Write(b []byte) (int, error) {
+	aead, _ := chacha20poly1305.New(c.writeKey)
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), 1024)
+		length := make([]byte, 2)
+		binary.LittleEndian.PutUint16(length, uint16(n))
+		frame := aead.Seal(length, c.nonce(c.writeCount), b[:n], length)
+		c.writeCount++
+		if _, err := c.Conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+func (c *hapConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, length); err != nil {
+			return 0, err
+		}
+		frame := make([]byte, int(binary.LittleEndian.Uint16(length))+chacha20poly1305.Overhead)
+		if _, err := io.ReadFull(c.Conn, frame); err != nil {
+			return 0, err
+		}
+		aead, _ := chacha20poly1305.New(c.readKey)
+		plain, err := aead.Open(nil, c.nonce(c.readCount), frame, length)
+		if err != nil {
+			return 0, errors.New("can't decrypt a frame from the device")
+		}
+		c.readCount++
+		c.pending = plain
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// fetchHAP reads the characteristics of a device over HomeKit and returns them
+// as a status.json would have them.
+func fetchHAP(ctx context.Context, t Target) ([]byte, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, err
+	}
+	hapMutex.Lock()
+	if hapPaired == nil {
+		if hapPaired, err = loadHAPPairings(); err != nil {
+			hapMutex.Unlock()
+			return nil, err
+		}
+	}
+	paired := hapPaired
+	accessory, ok := paired.Accessories[t.Name]
+	device := hapDevices[t.Name]
+	if device == nil {
+		device = &hapDevice{}
+		hapDevices[t.Name] = device
+	}
+	hapMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("not paired, pair the exporter with the device with: pair %s <setup code>", t.Name)
+	}
+
+	// a session is kept open between fetches, and opened again when it broke
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		if device.session == nil {
+			if device.session, err = hapConnect(ctx, u.Host, paired, accessory); err != nil {
+				return nil, err
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			device.session.conn.SetDeadline(deadline)
+		}
+		var body []byte
+		if body, err = device.session.get(u.Host, "/accessories"); err == nil {
+			return hapStatus(body, accessory.ID)
+		}
+		device.session.conn.Close()
+		device.session = nil
+		if attempt > 0 || ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Debug("HomeKit session broke, connecting again", "device", t.Name, "err", err)
+	}
+}
+
+func (s *hapSession) get(host, path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(s.conn); err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(s.reader, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, path)
+	}
+	return body, nil
+}
+
+// hapType is the short form of a HomeKit UUID, like 41 for 00000041-0000-1000-8000-0026BB765291.
+func hapType(t string) string {
+	t = strings.ToUpper(strings.TrimSuffix(strings.ToUpper(t), "-0000-1000-8000-0026BB765291"))
+	return strings.TrimLeft(t, "0")
+}
+
+// hapStatus maps the accessories of a device to the status.json fields.
+func hapStatus(body []byte, accessoryID string) ([]byte, error) {
+	var accessories struct {
+		Accessories []struct {
+			Services []struct {
+				Type            string `json:"type"`
+				Characteristics []struct {
+					Type  string      `json:"type"`
+					Value interface{} `json:"value"`
+				} `json:"characteristics"`
+			} `json:"services"`
+		} `json:"accessories"`
+	}
+	if err := json.Unmarshal(body, &accessories); err != nil {
+		return nil, err
+	}
+	number := func(v interface{}) int {
+		switch v := v.(type) {
+		case float64:
+			return int(v)
+		case bool:
+			if v {
+				return 1
+			}
+		}
+		return 0
+	}
+	status := Status{AccessoryID: accessoryID, Paired: true}
+	found := false
+	for _, a := range accessories.Accessories {
+		for _, s := range a.Services {
+			for _, c := range s.Characteristics {
+				switch hapType(s.Type) + "/" + hapType(c.Type) {
+				case "3E/23":
+					status.DeviceName, _ = c.Value.(string)
+				case "3E/52":
+					status.FirmwareVersion, _ = c.Value.(string)
+				case "41/E":
+					found = true
+					status.GarageDoorState = []string{"Open", "Closed", "Opening", "Closing", "Stopped"}[min(max(number(c.Value), 0), 4)]
+				case "41/24":
+					status.GarageObstructed = number(c.Value) != 0
+				case "41/1D":
+					status.GarageLockState = []string{"Unsecured", "Secured", "Jammed", "Unknown"}[min(max(number(c.Value), 0), 3)]
+				case "43/25":
+					status.GarageLightOn = number(c.Value) != 0
+				case "85/22":
+					status.GarageMotion = number(c.Value) != 0
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, errors.New("the device has no garage door opener")
+	}
+	return json.Marshal(status)
+}
+
+// runPair pairs the exporter with a hap:// device, given its name and setup code.
+func runPair(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s pair [flags] <device> <setup code>\n", os.Args[0])
+		os.Exit(2)
+	}
+	t, ok := findTarget(args[0])
+	if !ok || !t.hap() {
+		fatal("Not a hap:// device", "device", args[0])
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		fatal("Invalid address", "device", t.Name, "err", err)
+	}
+	p, err := loadHAPPairings()
+	if err != nil {
+		fatal("Error reading pairings", "file", hapPairingsFile, "err", err)
+	}
+	if p.ControllerID == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		p.ControllerID = fmt.Sprintf("%X-%X-%X-%X-%X", id[:4], id[4:6], id[6:8], id[8:10], id[10:])
+		p.ControllerKey = make([]byte, ed25519.SeedSize)
+		rand.Read(p.ControllerKey)
+	}
+	accessory, err := hapPair(u.Host, args[1], p)
+	if err != nil {
+		fatal("Error pairing", "device", t.Name, "err", err)
+	}
+	p.Accessories[t.Name] = accessory
+	if err := p.save(); err != nil {
+		fatal("Error saving pairings", "file", hapPairingsFile, "err", err)
+	}
+	slog.Info("Paired", "device", t.Name, "accessoryID", accessory.ID, "file", hapPairingsFile)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestHAPTLV(t *testing.T) {
+	long := bytes.Repeat([]byte{0xab}, 300)
+	b := hapTLV(tlvState, byte(3), tlvIdentifier, "controller", tlvPublicKey, long, tlvProof, []byte{})
+	// the long value is split into a fragment of 255 bytes and one of 45
+	if want := 3 + 2 + len("controller") + 2 + 255 + 2 + 45 + 2; len(b) != want {
+		t.Fatalf("encoded %d bytes, want %d", len(b), want)
+	}
+	items, err := parseHAPTLV(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		typ  byte
+		want []byte
+	}{
+		{tlvState, []byte{3}},
+		{tlvIdentifier, []byte("controller")},
+		{tlvPublicKey, long},
+		{tlvProof, []byte{}},
+	}
+	for _, tt := range tests {
+		if got, ok := items[tt.typ]; !ok || !bytes.Equal(got, tt.want) {
+			t.Errorf("type %d: got %x, want %x", tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestParseHAPTLVErrors(t *testing.T) {
+	if _, err := parseHAPTLV([]byte{tlvState, 5, 1}); err == nil {
+		t.Error("truncated TLV: no error")
+	}
+	_, err := parseHAPTLV(hapTLV(tlvState, byte(2), tlvError, byte(2)))
+	var hapErr hapError
+	if !errors.As(err, &hapErr) || hapErr != 2 {
+		t.Errorf("got %v, want the wrong setup code error", err)
+	}
+}
+
+func hexInt(t *testing.T, s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.ReplaceAll(s, " ", ""), 16)
+	if !ok {
+		t.Fatalf("invalid number %q", s)
+	}
+	return n
+}
+
+// TestSRP checks the SRP client against the test vectors of RFC 5054, appendix B.
+func TestSRP(t *testing.T) {
+	s := srpGroup{
+		n: hexInt(t, "EEAF0AB9 ADB38DD6 9C33F80A FA8FC5E8 60726187 75FF3C0B 9EA2314C 9C256576 D674DF74 96EA81D3 383B4813 D692C6E0 E0D5D8E2 50B98BE4 8E495C1D 6089DAD1 5DC7D7B4 6154D6B6 CE8EF4AD 69B15D49 82559B29 7BCF1885 C529F566 660E57EC 68EDBC3C 05726CC0 2FD4CBF4 976EAA9A FD5138FE 8376435B 9FC61D2F C0EB06E3"),
+		g: big.NewInt(2),
+		hash: func(parts ...[]byte) []byte {
+			h := sha1.New()
+			for _, p := range parts {
+				h.Write(p)
+			}
+			return h.Sum(nil)
+		},
+	}
+	salt := hexInt(t, "BEB25379 D1A8581E B5A72767 3A2441EE").Bytes()
+	a := hexInt(t, "60975527 035CF2AD 1989806F 0407210B C81EDC04 E2762A56 AFD529DD DA2D4393")
+	serverPublic := hexInt(t, "BD0C6151 2C692C0C B6D041FA 01BB152D 4916A1E7 7AF46AE1 05393011 BAF38964 DC46A067 0DD125B9 5A981652 236F99D9 B681CBF8 7837EC99 6C6DA044 53728610 D0C6DDB5 8B318885 D7D82C7F 8DEB75CE 7BD4FBAA 37089E6F 9C6059F3 88838E7A 00030B33 1EB76840 910440B1 B27AAEAE EB4012B7 D7665238 A8E3FB00 4B117B58")
+	public := s.public(a)
+
+	tests := []struct {
+		name string
+		got  *big.Int
+		want string
+	}{
+		{"k", s.k(), "7556AA04 5AEF2CDD 07ABAF0F 665C3E81 8913186F"},
+		{"x", s.x(salt, "alice", "password123"), "94B7555A ABE9127C C58CCF49 93DB6CF8 4D16C124"},
+		{"A", public, "61D5E490 F6F1B795 47B0704C 436F523D D0E560F0 C64115BB 72557EC4 4352E890 3211C046 92272D8B 2D1A5358 A2CF1B6E 0BFCF99F 921530EC 8E393561 79EAE45E 42BA92AE ACED8251 71E1E8B9 AF6D9C03 E1327F44 BE087EF0 6530E69F 66615261 EEF54073 CA11CF58 58F0EDFD FE15EFEA B349EF5D 76988A36 72FAC47B 0769447B"},
+		{"u", s.u(public, serverPublic), "CE38B959 3487DA98 554ED47D 70A7AE5F 462EF019"},
+		{"S", s.premaster(a, serverPublic, salt, "alice", "password123"), "B0DC82BA BCF30674 AE450C02 87745E79 90A3381F 63B387AA F271A10D 233861E3 59B48220 F7C4693C 9AE12B0A 6F67809F 0876E2D0 13800D6C 41BB59B6 D5979B5C 00A172B4 A2A5903A 0BDCAF8A 709585EB 2AFAFA8F 3499B200 210DCC1F 10EB3394 3CD67FC8 8A2F39A4 BE5BEC4E C0A3212D C346D7E4 74B29EDE 8A469FFE CA686E5A"},
+	}
+	for _, tt := range tests {
+		if want := hexInt(t, tt.want); tt.got.Cmp(want) != 0 {
+			t.Errorf("%s = %X, want %X", tt.name, tt.got, want)
+		}
+	}
+}
+
+func TestHAPStatus(t *testing.T) {
+	const accessories = `{"accessories": [{"services": [
+		{"type": "0000003E-0000-1000-8000-0026BB765291", "characteristics": [
+			{"type": "23", "value": "Garage"},
+			{"type": "00000052-0000-1000-8000-0026BB765291", "value": "2.1.0"}
+		]},
+		{"type": "41", "characteristics": [
+			{"type": "E", "value": %s},
+			{"type": "24", "value": true},
+			{"type": "1D", "value": 1}
+		]},
+		{"type": "43", "characteristics": [{"type": "25", "value": 1}]},
+		{"type": "85", "characteristics": [{"type": "22", "value": false}]}
+	]}]}`
+	tests := []struct {
+		doorState string
+		want      string
+	}{
+		{"0", "Open"},
+		{"1", "Closed"},
+		{"2", "Opening"},
+		{"3", "Closing"},
+		{"4", "Stopped"},
+		// out of range values are clamped
+		{"9", "Stopped"},
+		{"null", "Open"},
+	}
+	for _, tt := range tests {
+		body, err := hapStatus([]byte(strings.Replace(accessories, "%s", tt.doorState, 1)), "AA:BB")
+		if err != nil {
+			t.Fatalf("door state %s: %v", tt.doorState, err)
+		}
+		var s Status
+		if err := json.Unmarshal(body, &s); err != nil {
+			t.Fatal(err)
+		}
+		want := Status{
+			AccessoryID:      "AA:BB",
+			Paired:           true,
+			DeviceName:       "Garage",
+			FirmwareVersion:  "2.1.0",
+			GarageDoorState:  tt.want,
+			GarageObstructed: true,
+			GarageLockState:  "Secured",
+			GarageLightOn:    true,
+		}
+		if s != want {
+			t.Errorf("door state %s: got %+v, want %+v", tt.doorState, s, want)
+		}
+	}
+}
+
+func TestHAPStatusWithoutOpener(t *testing.T) {
+	body := `{"accessories": [{"services": [{"type": "43", "characteristics": [{"type": "25", "value": true}]}]}]}`
+	if _, err := hapStatus([]byte(body), "AA:BB"); err == nil {
+		t.Error("no error for a device without a garage door opener")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+)
+
+var (
+	readyNeedsFetch   bool
+	warmup            bool
+	warmupNeedsDevice bool
+
+	// set once the warmup collection is done, or right away without one
+	warmedUp atomic.Bool
+)
+
+func init() {
+	flag.BoolVar(&readyNeedsFetch, "web.ready-needs-fetch", false, "Have /readyz fail until a device was fetched successfully")
+	flag.BoolVar(&warmup, "web.warmup", true, "Fetch all devices once on startup and have /readyz fail until that is done")
+	flag.BoolVar(&warmupNeedsDevice, "web.warmup-needs-device", false, "Exit when no device could be fetched in the warmup on startup")
+}
+
+// startWarmup fetches all devices once in the background. A standby of a
+// high availability pair doesn't fetch devices, so it is ready right away.
+func startWarmup() {
+	if !warmup || !isLeader() {
+		warmedUp.Store(true)
+		return
+	}
+	go func() {
+		collect(context.Background())
+		fetched, reachable := 0, 0
+		for _, t := range currentTargets() {
+			if maintenanceMode(t.Name) == maintenancePause {
+				continue
+			}
+			fetched++
+			d := getDevice(t.Name)
+			d.mu.Lock()
+			if d.Online {
+				reachable++
+			}
+			d.mu.Unlock()
+		}
+		// without devices to fetch, e.g. before discovery found any, there is nothing to fail
+		if warmupNeedsDevice && fetched > 0 && reachable == 0 {
+			fatal("No device could be fetched on startup", "devices", fetched)
+		}
+		slog.Info("Warmed up", "devices", fetched, "reachable", reachable)
+		warmedUp.Store(true)
+	}()
+}
+
+// healthzHandler is the liveness probe; it answers as long as the process runs.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler is the readiness probe. The server only starts once the config
+// is loaded, so that is implied; the warmup has to be done too, and with
+// -web.ready-needs-fetch a device must also have been fetched.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !warmedUp.Load() {
+		http.Error(w, "warming up", http.StatusServiceUnavailable)
+		return
+	}
+	if readyNeedsFetch {
+		fetched := false
+		for _, d := range allDevices() {
+			d.mu.Lock()
+			if d.Seen {
+				fetched = true
+			}
+			d.mu.Unlock()
+		}
+		if !fetched {
+			http.Error(w, "no device fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	healthcheckURL      string
+	healthcheckInterval time.Duration
+
+	healthcheckMutex    sync.Mutex
+	lastHeartbeat       time.Time
+	lastHeartbeatFailed bool
+)
+
+func init() {
+	flag.StringVar(&healthcheckURL, "healthcheck.url", "", "Healthchecks.io (or compatible) ping URL, pinged after collections and with /fail appended when they fail")
+	flag.DurationVar(&healthcheckInterval, "healthcheck.interval", time.Minute, "Minimum time between heartbeat pings unless the result changes")
+}
+
+// heartbeat pings the dead man's switch with the result of a collection.
+func heartbeat(err error) {
+	if healthcheckURL == "" {
+		return
+	}
+
+	healthcheckMutex.Lock()
+	failed := err != nil
+	if time.Since(lastHeartbeat) < healthcheckInterval && failed == lastHeartbeatFailed {
+		healthcheckMutex.Unlock()
+		return
+	}
+	lastHeartbeat = time.Now()
+	lastHeartbeatFailed = failed
+	healthcheckMutex.Unlock()
+
+	url := healthcheckURL
+	body := "ok"
+	if failed {
+		url = strings.TrimSuffix(url, "/") + "/fail"
+		body = err.Error()
+	}
+	go func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(url, "text/plain", strings.NewReader(body))
+		if err != nil {
+			slog.Error("Error pinging healthcheck", "err", err)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			slog.Error("Error pinging healthcheck", "status", resp.Status)
+		}
+	}()
+}
+
+// pingPolls pings the dead man's switch once per poll interval, the shortest
+// one with devices that have their own, with how the last fetches of all
+// devices went. The devices are polled one by one, so pinging after each would
+// ping for every device, and the last one's result would hide the others'.
+func pingPolls() {
+	for {
+		var interval time.Duration
+		for _, t := range currentTargets() {
+			if i := t.pollInterval(); i > 0 && (interval == 0 || i < interval) && maintenanceMode(t.Name) != maintenancePause {
+				interval = i
+			}
+		}
+		// without polling the scrapes ping; devices may be polled after a reload
+		if interval == 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(interval)
+		if isLeader() {
+			heartbeat(lastFetchError())
+		}
+	}
+}
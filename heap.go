@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// heapSample is a device's free heap at one fetch.
+type heapSample struct {
+	at   time.Time
+	free float64
+}
+
+var (
+	heapTrendWindow    time.Duration
+	heapExhaustedBelow int
+
+	// per device since its last reboot, oldest first; guarded by mutex
+	heapSamples = map[string][]heapSample{}
+	heapUpTimes = map[string]int64{}
+
+	heapExhaustion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_heap_exhaustion_seconds",
+		Help: "Estimated seconds until the free heap drops below -heap.exhausted-below, from its trend; absent while it isn't falling.",
+	}, []string{"location", "device", "displayName"})
+)
+
+func init() {
+	flag.DurationVar(&heapTrendWindow, "heap.trend-window", 6*time.Hour, "How far back free heap samples are fitted for homekit_ratgdo_heap_exhaustion_seconds")
+	flag.IntVar(&heapExhaustedBelow, "heap.exhausted-below", 4096, "Free heap in bytes below which a device counts as out of memory for homekit_ratgdo_heap_exhaustion_seconds")
+	prometheus.MustRegister(heapExhaustion)
+	onUpdate(estimateHeapExhaustion)
+}
+
+// estimateHeapExhaustion fits a line through the free heap since the device
+// rebooted, within -heap.trend-window, and extrapolates it to
+// -heap.exhausted-below. It waits for samples spanning a quarter of the window,
+// as a few minutes of heap say little about a leak.
+func estimateHeapExhaustion(d *deviceState) {
+	if !d.Online || d.Status.FreeHeap <= 0 {
+		return
+	}
+	now := d.LastUpdate
+	// a reboot gives the heap back, the trend starts over
+	if d.Status.UpTime < heapUpTimes[d.Name] {
+		delete(heapSamples, d.Name)
+	}
+	heapUpTimes[d.Name] = d.Status.UpTime
+	samples := append(heapSamples[d.Name], heapSample{at: now, free: float64(d.Status.FreeHeap)})
+	for len(samples) > 0 && now.Sub(samples[0].at) > heapTrendWindow {
+		samples = samples[1:]
+	}
+	heapSamples[d.Name] = samples
+
+	labels := prometheus.Labels{"location": targetLocation(d.Name), "device": d.Name, "displayName": targetDisplayName(d.Name)}
+	if len(samples) < 3 || now.Sub(samples[0].at) < heapTrendWindow/4 {
+		heapExhaustion.Delete(labels)
+		return
+	}
+	free, slope := heapTrend(samples, now)
+	if slope >= 0 {
+		heapExhaustion.Delete(labels)
+		return
+	}
+	heapExhaustion.With(labels).Set(max((free-float64(heapExhaustedBelow))/-slope, 0))
+}
+
+// heapTrend fits a line through the samples by least squares. It returns the
+// fitted heap at now, less jumpy than the last sample, and its change in bytes
+// per second. The samples must be at two times at least.
+func heapTrend(samples []heapSample, now time.Time) (free, slope float64) {
+	// in seconds before now
+	var sumX, sumY, sumXX, sumXY float64
+	for _, s := range samples {
+		x := s.at.Sub(now).Seconds()
+		sumX += x
+		sumY += s.free
+		sumXX += x * x
+		sumXY += x * s.free
+	}
+	n := float64(len(samples))
+	slope = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	return sumY/n - slope*sumX/n, slope
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHeapTrend(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		before    []time.Duration
+		free      []float64
+		wantFree  float64
+		wantSlope float64
+	}{
+		{"exact", []time.Duration{2 * time.Hour, time.Hour, 0}, []float64{47200, 43600, 40000}, 40000, -1},
+		{"noisy", []time.Duration{time.Hour, 40 * time.Minute, 20 * time.Minute, 0}, []float64{50000, 49000, 48500, 47000}, 47200, -0.7916667},
+		// without a sample now the fit is extrapolated
+		{"old samples", []time.Duration{2 * time.Hour, time.Hour, 10 * time.Minute}, []float64{30000, 30400, 29800}, 29969.2308, -0.0256410},
+		{"flat", []time.Duration{2 * time.Hour, time.Hour, 0}, []float64{30000, 30000, 30000}, 30000, 0},
+		{"rising", []time.Duration{2 * time.Hour, 0}, []float64{30000, 37200}, 37200, 1},
+	}
+	for _, tt := range tests {
+		var samples []heapSample
+		for i, b := range tt.before {
+			samples = append(samples, heapSample{at: now.Add(-b), free: tt.free[i]})
+		}
+		free, slope := heapTrend(samples, now)
+		if math.Abs(free-tt.wantFree) > 0.001 || math.Abs(slope-tt.wantSlope) > 1e-6 {
+			t.Errorf("%s: got %.4f bytes, %.7f bytes/s, want %.4f, %.7f", tt.name, free, slope, tt.wantFree, tt.wantSlope)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historySample is a device's state at one fetch, kept small as there are many.
+type historySample struct {
+	Time       time.Time
+	Online     bool
+	DoorOpen   bool
+	LightOn    bool
+	Motion     bool
+	Obstructed bool
+	FreeHeap   int32
+	MinHeap    int32
+	CrashCount int32
+}
+
+var (
+	historyRetention time.Duration
+	historyMax       int
+	// per device, oldest first; guarded by mutex
+	history = map[string][]historySample{}
+
+	// historyMetrics are the values /api/v1/history serves, by name.
+	historyMetrics = map[string]func(s historySample) float64{
+		"online":      func(s historySample) float64 { return boolToFloat(s.Online) },
+		"door_state":  func(s historySample) float64 { return boolToFloat(s.DoorOpen) },
+		"light_on":    func(s historySample) float64 { return boolToFloat(s.LightOn) },
+		"motion":      func(s historySample) float64 { return boolToFloat(s.Motion) },
+		"obstructed":  func(s historySample) float64 { return boolToFloat(s.Obstructed) },
+		"free_heap":   func(s historySample) float64 { return float64(s.FreeHeap) },
+		"min_heap":    func(s historySample) float64 { return float64(s.MinHeap) },
+		"crash_count": func(s historySample) float64 { return float64(s.CrashCount) },
+	}
+)
+
+func init() {
+	flag.DurationVar(&historyRetention, "history.retention", 24*time.Hour, "How long recent samples are kept in memory for /api/v1/history")
+	flag.IntVar(&historyMax, "history.max", 20000, "Maximum number of samples kept per device for /api/v1/history")
+
+	onUpdate(func(d *deviceState) {
+		if !d.Seen {
+			return
+		}
+		now := time.Now()
+		samples := append(history[d.Name], historySample{
+			Time:       now,
+			Online:     d.Online,
+			DoorOpen:   d.Status.GarageDoorState != "Closed",
+			LightOn:    d.Status.GarageLightOn,
+			Motion:     d.Status.GarageMotion,
+			Obstructed: d.Status.GarageObstructed,
+			FreeHeap:   int32(d.Status.FreeHeap),
+			MinHeap:    int32(d.Status.MinHeap),
+			CrashCount: int32(d.Status.CrashCount),
+		})
+		for len(samples) > 0 && (len(samples) > historyMax || now.Sub(samples[0].Time) > historyRetention) {
+			samples = samples[1:]
+		}
+		history[d.Name] = samples
+	})
+}
+
+func historyMetricNames() string {
+	var names []string
+	for name := range historyMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// historyHandler serves /api/v1/history/{device}?metric=door_state, optionally
+// with ?since=, as [unix time, value] pairs oldest first.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/history"), "/")
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "door_state"
+	}
+	value, ok := historyMetrics[metric]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "unknown metric "+metric+", use one of "+historyMetricNames())
+		return
+	}
+	since, err := parseTime(r.URL.Query().Get("since"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid since")
+		return
+	}
+
+	mutex.Lock()
+	_, known := allDevices()[name]
+	if visible := visibleDevices(r); visible != nil && !visible[name] {
+		known = false
+	}
+	points := [][2]float64{}
+	for _, s := range history[name] {
+		// only connectivity is known while the device is offline
+		if s.Time.Before(since) || (!s.Online && metric != "online") {
+			continue
+		}
+		points = append(points, [2]float64{float64(s.Time.UnixMilli()) / 1000, value(s)})
+	}
+	mutex.Unlock()
+
+	if !known {
+		writeJSONError(w, http.StatusNotFound, "unknown device "+name)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"device": name, "metric": metric, "samples": points})
+}
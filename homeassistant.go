@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	discoveryPrefix string
+
+	nonIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+func init() {
+	flag.StringVar(&discoveryPrefix, "mqtt.discovery-prefix", "homeassistant", "Home Assistant MQTT discovery prefix (empty disables discovery)")
+}
+
+type haDevice struct {
+	Identifiers  []string    `json:"identifiers"`
+	Connections  [][2]string `json:"connections,omitempty"`
+	Name         string      `json:"name"`
+	Manufacturer string      `json:"manufacturer"`
+	Model        string      `json:"model"`
+	SWVersion    string      `json:"sw_version,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+type haConfig struct {
+	Name             string           `json:"name"`
+	UniqueID         string           `json:"unique_id"`
+	ObjectID         string           `json:"object_id"`
+	StateTopic       string           `json:"state_topic"`
+	DeviceClass      string           `json:"device_class,omitempty"`
+	StateClass       string           `json:"state_class,omitempty"`
+	UnitOfMeasure    string           `json:"unit_of_measurement,omitempty"`
+	PayloadOn        string           `json:"payload_on,omitempty"`
+	PayloadOff       string           `json:"payload_off,omitempty"`
+	StateOpen        string           `json:"state_open,omitempty"`
+	StateClosed      string           `json:"state_closed,omitempty"`
+	StateOpening     string           `json:"state_opening,omitempty"`
+	StateClosing     string           `json:"state_closing,omitempty"`
+	StateStopped     string           `json:"state_stopped,omitempty"`
+	Availability     []haAvailability `json:"availability"`
+	AvailabilityMode string           `json:"availability_mode"`
+	Device           haDevice         `json:"device"`
+}
+
+// subscribeHomeAssistant republishes the discovery configs whenever Home Assistant restarts.
+func subscribeHomeAssistant(c mqtt.Client) {
+	if discoveryPrefix == "" {
+		return
+	}
+	c.Subscribe(discoveryPrefix+"/status", 1, func(c mqtt.Client, m mqtt.Message) {
+		if string(m.Payload()) != "online" {
+			return
+		}
+		go func() {
+			mutex.Lock()
+			for topic := range mqttPublished {
+				if strings.HasPrefix(topic, discoveryPrefix+"/") {
+					delete(mqttPublished, topic)
+				}
+			}
+			mutex.Unlock()
+			for _, d := range allDevices() {
+				d.mu.Lock()
+				mutex.Lock()
+				publishDiscovery(d)
+				mutex.Unlock()
+				d.mu.Unlock()
+			}
+		}()
+	})
+}
+
+func publishDiscovery(d *deviceState) {
+	if discoveryPrefix == "" || !d.Seen {
+		return
+	}
+
+	id := d.Status.MacAddress
+	if id == "" {
+		id = d.Name
+	}
+	nodeID := "ratgdo_" + strings.Trim(nonIDChars.ReplaceAllString(strings.ToLower(id), "_"), "_")
+
+	name := d.Status.DeviceName
+	if name == "" {
+		name = d.Name
+	}
+	device := haDevice{
+		Identifiers:  []string{nodeID},
+		Name:         name,
+		Manufacturer: "ratgdo",
+		Model:        "homekit-ratgdo",
+		SWVersion:    d.Status.FirmwareVersion,
+	}
+	if d.Status.MacAddress != "" {
+		device.Connections = [][2]string{{"mac", strings.ToLower(d.Status.MacAddress)}}
+	}
+
+	entity := func(component, object, entityName string, c haConfig) {
+		c.Name = entityName
+		c.UniqueID = nodeID + "_" + object
+		c.ObjectID = nodeID + "_" + object
+		c.Availability = []haAvailability{{Topic: mqttTopic("status")}, {Topic: mqttTopic(d.Name, "availability")}}
+		c.AvailabilityMode = "all"
+		c.Device = device
+
+		payload, err := json.Marshal(c)
+		if err != nil {
+			slog.Error("Error marshalling discovery config", "device", d.Name, "err", err)
+			return
+		}
+		mqttPublish(strings.Join([]string{discoveryPrefix, component, nodeID, object, "config"}, "/"), string(payload))
+	}
+
+	entity("cover", "door", "Door", haConfig{
+		StateTopic:   mqttTopic(d.Name, "door"),
+		DeviceClass:  "garage",
+		StateOpen:    "open",
+		StateClosed:  "closed",
+		StateOpening: "opening",
+		StateClosing: "closing",
+		StateStopped: "stopped",
+	})
+	// the exporter can't switch the light, so it is exposed as a sensor rather than a light entity
+	entity("binary_sensor", "light", "Light", haConfig{
+		StateTopic:  mqttTopic(d.Name, "light"),
+		DeviceClass: "light",
+		PayloadOn:   "on",
+		PayloadOff:  "off",
+	})
+	entity("binary_sensor", "motion", "Motion", haConfig{
+		StateTopic:  mqttTopic(d.Name, "motion"),
+		DeviceClass: "motion",
+		PayloadOn:   "on",
+		PayloadOff:  "off",
+	})
+	entity("binary_sensor", "obstruction", "Obstruction", haConfig{
+		StateTopic:  mqttTopic(d.Name, "obstruction"),
+		DeviceClass: "problem",
+		PayloadOn:   "on",
+		PayloadOff:  "off",
+	})
+	entity("sensor", "open_duration", "Open duration", haConfig{
+		StateTopic:    mqttTopic(d.Name, "open_duration"),
+		DeviceClass:   "duration",
+		StateClass:    "measurement",
+		UnitOfMeasure: "s",
+	})
+	entity("sensor", "cycles", "Door cycles", haConfig{
+		StateTopic: mqttTopic(d.Name, "cycles"),
+		StateClass: "total_increasing",
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// These are about requests to the exporter, homekit_ratgdo_request_count is
+// about the exporter's requests to the devices.
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_exporter_http_requests_total",
+		Help: "Requests to the exporter's HTTP server, labeled by handler, status code and method.",
+	}, []string{"handler", "code", "method"})
+	httpRequestDuration = prometheus.NewHistogramVec(nativeHistogram(prometheus.HistogramOpts{
+		Name:    "homekit_ratgdo_exporter_http_request_duration_seconds",
+		Help:    "How long the exporter took to answer requests, labeled by handler and method. Streams count until they disconnect.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}), []string{"handler", "method"})
+	httpRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_exporter_http_requests_in_flight",
+		Help: "Requests the exporter is answering right now, labeled by handler.",
+	}, []string{"handler"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpRequestDuration, httpRequestsInFlight)
+}
+
+// nativeHistogram makes a histogram a native one as well. Scrapers asking for
+// the protobuf format get both, the text format only has the classic buckets.
+func nativeHistogram(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	// buckets grow by at most 10%, halving the resolution when there are too many
+	opts.NativeHistogramBucketFactor = 1.1
+	opts.NativeHistogramMaxBucketNumber = 100
+	opts.NativeHistogramMinResetDuration = time.Hour
+	return opts
+}
+
+// instrument counts and times the requests to the handler registered for pattern.
+func instrument(pattern string, h http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": pattern}
+	return promhttp.InstrumentHandlerInFlight(httpRequestsInFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), h)))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// runInflux fetches the devices once and prints their metrics in Influx line
+// protocol, for Telegraf's exec input.
+func runInflux() {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	// unreachable devices are in homekit_ratgdo_up
+	collect(ctx)
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		fatal("Error gathering metrics", "err", err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	writeInflux(w, families, time.Now())
+	if err := w.Flush(); err != nil {
+		fatal("Error writing metrics", "err", err)
+	}
+}
+
+// writeInflux writes the device metrics as one line per series, with the
+// metric's name as the measurement, its labels as tags and a value field.
+// Histograms and summaries have count and sum fields, and one per bucket
+// or quantile.
+func writeInflux(w io.Writer, families []*dto.MetricFamily, now time.Time) {
+	for _, f := range families {
+		name := f.GetName()
+		// the exporter's own metrics say nothing after a single collection
+		if !strings.HasPrefix(name, "homekit_ratgdo_") || strings.HasPrefix(name, "homekit_ratgdo_exporter_") {
+			continue
+		}
+		for _, m := range f.Metric {
+			fields := map[string]float64{}
+			switch {
+			case m.Counter != nil:
+				fields["value"] = m.Counter.GetValue()
+			case m.Gauge != nil:
+				fields["value"] = m.Gauge.GetValue()
+			case m.Untyped != nil:
+				fields["value"] = m.Untyped.GetValue()
+			case m.Histogram != nil:
+				fields["count"], fields["sum"] = float64(m.Histogram.GetSampleCount()), m.Histogram.GetSampleSum()
+				for _, b := range m.Histogram.Bucket {
+					fields[strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)] = float64(b.GetCumulativeCount())
+				}
+			case m.Summary != nil:
+				fields["count"], fields["sum"] = float64(m.Summary.GetSampleCount()), m.Summary.GetSampleSum()
+				for _, q := range m.Summary.Quantile {
+					fields[strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)] = q.GetValue()
+				}
+			}
+
+			var line strings.Builder
+			line.WriteString(influxEscaper.Replace(name))
+			labels := m.Label
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			for _, l := range labels {
+				// line protocol has no empty tags
+				if l.GetValue() != "" {
+					fmt.Fprintf(&line, ",%s=%s", influxEscaper.Replace(l.GetName()), influxEscaper.Replace(l.GetValue()))
+				}
+			}
+			var keys []string
+			for k, v := range fields {
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					continue
+				}
+				keys = append(keys, k)
+			}
+			if len(keys) == 0 {
+				continue
+			}
+			sort.Strings(keys)
+			for i, k := range keys {
+				sep := ","
+				if i == 0 {
+					sep = " "
+				}
+				fmt.Fprintf(&line, "%s%s=%s", sep, influxEscaper.Replace(k), strconv.FormatFloat(fields[k], 'g', -1, 64))
+			}
+			fmt.Fprintf(w, "%s %d\n", line.String(), now.UnixNano())
+		}
+	}
+}
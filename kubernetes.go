@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	kubernetesAnnotation     = "ratgdo.exporter/"
+	kubernetesServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount/"
+)
+
+var (
+	kubernetesDiscovery       bool
+	kubernetesNamespace       string
+	kubernetesRefreshInterval time.Duration
+)
+
+func init() {
+	flag.BoolVar(&kubernetesDiscovery, "kubernetes.discovery", false, "Discover devices from Kubernetes Services annotated with ratgdo.exporter/scrape: \"true\", running in the cluster")
+	flag.StringVar(&kubernetesNamespace, "kubernetes.namespace", "", "Only discover Services in this namespace (all namespaces when empty)")
+	flag.DurationVar(&kubernetesRefreshInterval, "kubernetes.refresh-interval", time.Minute, "How often to look for added or removed Services")
+}
+
+// kubernetesService is the part of a Service that discovery looks at.
+type kubernetesService struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Ports []struct {
+			Port int `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// kubernetesClient returns a client for the API server of the cluster the
+// exporter runs in, with the service account's token.
+func kubernetesClient() (*http.Client, string, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", "", fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(kubernetesServiceAccount + "token")
+	if err != nil {
+		return nil, "", "", err
+	}
+	pool, err := certPool(kubernetesServiceAccount + "ca.crt")
+	if err != nil {
+		return nil, "", "", err
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return client, "https://" + net.JoinHostPort(host, port), strings.TrimSpace(string(token)), nil
+}
+
+// discoverKubernetes lists the annotated Services as targets.
+func discoverKubernetes() ([]Target, error) {
+	client, server, token, err := kubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+	path := "/api/v1/services"
+	if kubernetesNamespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(kubernetesNamespace) + "/services"
+	}
+	req, err := http.NewRequest(http.MethodGet, server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing services: %s", resp.Status)
+	}
+	var list struct {
+		Items []kubernetesService `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	seen := map[string]bool{}
+	for _, s := range list.Items {
+		t, ok := s.target()
+		if !ok {
+			continue
+		}
+		if seen[t.Name] {
+			slog.Warn("Discovered two devices with the same name, ignoring one", "device", t.Name, "namespace", s.Metadata.Namespace, "service", s.Metadata.Name)
+			continue
+		}
+		seen[t.Name] = true
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets, nil
+}
+
+// target is the device behind an annotated Service. ratgdo.exporter/name,
+// port and path override the device name (the Service's), the port (its
+// first) and /status.json, and interval sets its own poll interval.
+func (s kubernetesService) target() (Target, bool) {
+	a := s.Metadata.Annotations
+	if a[kubernetesAnnotation+"scrape"] != "true" {
+		return Target{}, false
+	}
+	name := a[kubernetesAnnotation+"name"]
+	if name == "" {
+		name = s.Metadata.Name
+	}
+	port := a[kubernetesAnnotation+"port"]
+	if port == "" && len(s.Spec.Ports) > 0 {
+		port = fmt.Sprint(s.Spec.Ports[0].Port)
+	}
+	path := a[kubernetesAnnotation+"path"]
+	if path == "" {
+		path = "/status.json"
+	}
+	var interval time.Duration
+	if v := a[kubernetesAnnotation+"interval"]; v != "" {
+		var err error
+		if interval, err = time.ParseDuration(v); err != nil || interval < 0 {
+			slog.Warn("Invalid poll interval annotation, ignoring it", "namespace", s.Metadata.Namespace, "service", s.Metadata.Name, "interval", v)
+			interval = 0
+		}
+	}
+	host := s.Metadata.Name + "." + s.Metadata.Namespace + ".svc"
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return Target{Name: name, URL: "http://" + host + path, Source: "kubernetes", Interval: interval}, true
+}
+
+// watchKubernetes keeps the discovered targets up to date.
+func watchKubernetes() {
+	defer reportPanic()
+	for {
+		targets, err := discoverKubernetes()
+		if err != nil {
+			// keep the devices found last, the API server may be restarting
+			slog.Error("Error discovering devices in Kubernetes", "err", err)
+		} else if err := setDiscovered(targets); err != nil {
+			slog.Error("Error setting discovered devices", "err", err)
+		}
+		time.Sleep(kubernetesRefreshInterval)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	enableLifecycle bool
+	// closed to shut the exporter down
+	quit     = make(chan struct{})
+	quitOnce sync.Once
+
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_exporter_config_last_reload_successful",
+		Help: "Whether the last config reload worked.",
+	})
+	configReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "When the config was last loaded successfully.",
+	})
+)
+
+func init() {
+	flag.BoolVar(&enableLifecycle, "web.enable-lifecycle", false, "Enable shutting down and reloading the exporter via POST /-/quit and /-/reload, for API tokens with the admin scope")
+
+	prometheus.MustRegister(configReloadSuccess, configReloadTime)
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+}
+
+// reloadConfig reads the config and the bearer tokens again. Settings given
+// as flags, like -json-address, need a restart.
+func reloadConfig() error {
+	err := func() error {
+		if configFile != "" || configURL != "" {
+			c, err := readConfig()
+			if err != nil {
+				return err
+			}
+			if err := applyConfig(c); err != nil {
+				return err
+			}
+		}
+		return loadBearerTokens()
+	}()
+	if err != nil {
+		configReloadSuccess.Set(0)
+		slog.Error("Error reloading config", "from", configSource(), "err", err)
+		return err
+	}
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+	slog.Info("Reloaded config", "from", configSource())
+	return nil
+}
+
+// applyConfig makes c the config, with the devices it adds.
+func applyConfig(c *Config) error {
+	if _, err := staticTargets(c); err != nil {
+		return err
+	}
+	configPtr.Store(c)
+	return updateTargets()
+}
+
+// reloadOnHUP reloads the config whenever the process gets a SIGHUP.
+func reloadOnHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		reloadConfig()
+	}
+}
+
+// lifecycleHandler serves POST /-/reload and /-/quit.
+func lifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	if !enableLifecycle {
+		writeJSONError(w, http.StatusForbidden, "lifecycle endpoints are disabled, start with -web.enable-lifecycle")
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token, ok := authorize(w, r, scopeAdmin, "")
+	if !ok {
+		return
+	}
+	switch r.URL.Path {
+	case "/-/reload":
+		if err := reloadConfig(); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "error reloading config: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "reloaded"})
+	case "/-/quit":
+		slog.Info("Shutting down", "token", token.Name, "remote", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, map[string]string{"result": "shutting down"})
+		go func() {
+			// let the response go out first
+			time.Sleep(100 * time.Millisecond)
+			quitOnce.Do(func() { close(quit) })
+		}()
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file that is renamed to file.1, file.2, ... once it
+// is too large or too old, keeping a limited number of those.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	// when the current file was started, for -log.file.max-age
+	created time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.created = file, info.Size(), time.Now()
+	// a file written to before the exporter started keeps its age
+	if info.Size() > 0 {
+		f.created = fileCreated(f.path)
+	}
+	return nil
+}
+
+// fileCreated tells when the log file at path was started: when it was
+// created if the file system records that, else when the file before it was
+// rotated, else now.
+func fileCreated(path string) time.Time {
+	if t, ok := birthTime(path); ok {
+		return t
+	}
+	if info, err := os.Stat(path + ".1"); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
+
+// rotate shifts the backups up by one, dropping the oldest, and starts a new file.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if f.maxBackups > 0 {
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	tooLarge := f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize
+	tooOld := f.maxAge > 0 && time.Since(f.created) >= f.maxAge
+	if tooLarge || tooOld {
+		if err := f.rotate(); err != nil {
+			// keep logging to the old file rather than losing the message
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", f.path, err)
+			if f.file == nil || f.open() != nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
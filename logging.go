@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	logLevel         string
+	logFormat        string
+	logOutput        string
+	logSyslogAddress string
+	logFile          string
+	logFileMaxSize   int
+	logFileMaxAge    time.Duration
+	logFileBackups   int
+)
+
+func init() {
+	flag.StringVar(&logLevel, "log.level", "info", "Only log messages at this level or above: debug, info, warn or error")
+	flag.StringVar(&logFormat, "log.format", "text", "Log format on stderr: text (logfmt) or json")
+	flag.StringVar(&logOutput, "log.output", "stderr", "Where to log: stderr, syslog or journald (native, with the attributes as fields)")
+	flag.StringVar(&logSyslogAddress, "log.syslog-address", "", "Remote syslog server like udp://host:514 for -log.output=syslog (the local one when empty)")
+	flag.StringVar(&logFile, "log.file", "", "Log to this file instead of stderr, rotating it by -log.file.max-size and -log.file.max-age")
+	flag.IntVar(&logFileMaxSize, "log.file.max-size", 10, "Rotate -log.file once it would grow beyond this many megabytes (0 disables)")
+	flag.DurationVar(&logFileMaxAge, "log.file.max-age", 0, "Rotate -log.file once it has been written to this long, like 24h (0 disables)")
+	flag.IntVar(&logFileBackups, "log.file.max-backups", 5, "Number of rotated log files to keep, as file.1 (newest) to file.N")
+}
+
+// setupLogging sets the default slog logger, which the log package writes through too.
+func setupLogging(w io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid -log.level %q, use debug, info, warn or error", logLevel)
+	}
+	if logFile != "" && logOutput != "stderr" {
+		return fmt.Errorf("-log.file can't be used with -log.output %s", logOutput)
+	}
+	var handler slog.Handler
+	var err error
+	switch logOutput {
+	case "stderr":
+		if logFile != "" {
+			f, err := openRotatingFile(logFile, int64(logFileMaxSize)<<20, logFileMaxAge, logFileBackups)
+			if err != nil {
+				return fmt.Errorf("error opening log file: %v", err)
+			}
+			w = f
+		}
+		opts := &slog.HandlerOptions{Level: level}
+		switch logFormat {
+		case "text":
+			handler = slog.NewTextHandler(w, opts)
+		case "json":
+			handler = slog.NewJSONHandler(w, opts)
+		default:
+			return fmt.Errorf("invalid -log.format %q, use text or json", logFormat)
+		}
+	case "syslog":
+		if handler, err = newSyslogHandler(level, logSyslogAddress); err != nil {
+			return fmt.Errorf("error connecting to syslog: %v", err)
+		}
+	case "journald":
+		if handler, err = newJournalHandler(level); err != nil {
+			return fmt.Errorf("error connecting to journald: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid -log.output %q, use stderr, syslog or journald", logOutput)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs an error, reports it to Sentry if enabled, and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	if sentryEnabled {
+		r := slog.NewRecord(time.Now(), slog.LevelError, msg, 0)
+		r.Add(args...)
+		var attrs []slog.Attr
+		r.Attrs(func(a slog.Attr) bool {
+			attrs = append(attrs, a)
+			return true
+		})
+		sentry.CaptureMessage(logfmt(msg, attrs))
+		sentry.Flush(5 * time.Second)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LoginConfig is for devices whose firmware protects its pages with a login
+// form that sets a session cookie.
+type LoginConfig struct {
+	// URL is where the login form is posted to.
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// UsernameField and PasswordField are the names of the form's fields,
+	// username and password by default.
+	UsernameField string `json:"usernameField"`
+	PasswordField string `json:"passwordField"`
+}
+
+// deviceSession holds the cookies of a device with a login.
+type deviceSession struct {
+	mu       sync.Mutex
+	jar      http.CookieJar
+	loggedIn bool
+}
+
+var (
+	sessionsMutex sync.Mutex
+	// by device name; guarded by sessionsMutex
+	sessions = map[string]*deviceSession{}
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_logins_total",
+		Help: "Logins to devices with a login form, labeled by device and result.",
+	}, []string{"device", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(logins)
+}
+
+func (l *LoginConfig) validate() error {
+	if u, err := url.Parse(l.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", l.URL)
+	}
+	if l.UsernameField == "" {
+		l.UsernameField = "username"
+	}
+	if l.PasswordField == "" {
+		l.PasswordField = "password"
+	}
+	return nil
+}
+
+func session(name string) *deviceSession {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	s, ok := sessions[name]
+	if !ok {
+		jar, _ := cookiejar.New(nil)
+		s = &deviceSession{jar: jar}
+		sessions[name] = s
+	}
+	return s
+}
+
+// do sends a request to the device with client. For a device with a login it
+// logs in first, keeps the session's cookies, and logs in again once when
+// the device answers 401 because the session expired.
+func (t Target) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	login := currentConfig().Targets[t.Name].Login
+	if login == nil {
+		return client.Do(req)
+	}
+	s := session(t.Name)
+	withJar := *client
+	withJar.Jar = s.jar
+	if err := s.login(req.Context(), t, login, false); err != nil {
+		return nil, err
+	}
+	resp, err := withJar.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	slog.Info("Session expired, logging in again", "device", t.Name)
+	if err := s.login(req.Context(), t, login, true); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return withJar.Do(retry)
+}
+
+// login posts the login form, unless the session is logged in already and
+// again isn't set.
+func (s *deviceSession) login(ctx context.Context, t Target, l *LoginConfig, again bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loggedIn && !again {
+		return nil
+	}
+	s.loggedIn = false
+	form := url.Values{l.UsernameField: {l.Username}, l.PasswordField: {l.Password}}
+	req, err := t.newRequest(ctx, http.MethodPost, l.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Jar: s.jar, Timeout: fetchTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		logins.WithLabelValues(t.Name, "failure").Inc()
+		return fmt.Errorf("logging in: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		logins.WithLabelValues(t.Name, "failure").Inc()
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return errors.New("logging in: wrong username or password")
+		}
+		return fmt.Errorf("logging in: unexpected status %s", resp.Status)
+	}
+	logins.WithLabelValues(t.Name, "success").Inc()
+	s.loggedIn = true
+	return nil
+}
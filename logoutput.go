@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	logIdentifier = "homekit-ratgdo-exporter"
+	journalSocket = "/run/systemd/journal/socket"
+)
+
+// emitHandler is a slog.Handler for outputs that take the level, message and
+// flat key/value pairs of a record separately, like syslog and journald.
+type emitHandler struct {
+	level slog.Leveler
+	// attrs with their group prefix in the key
+	attrs  []slog.Attr
+	prefix string
+	emit   func(level slog.Level, msg string, attrs []slog.Attr) error
+}
+
+func (h *emitHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func appendAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			attrs = appendAttr(attrs, prefix, ga)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+}
+
+func (h *emitHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.prefix, a)
+		return true
+	})
+	return h.emit(r.Level, r.Message, attrs)
+}
+
+func (h *emitHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range as {
+		h2.attrs = appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *emitHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+// logfmt formats a message and its attributes as one line for syslog.
+func logfmt(msg string, attrs []slog.Attr) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, a := range attrs {
+		v := a.Value.String()
+		if v == "" || strings.ContainsAny(v, " \"=\n") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+	}
+	return b.String()
+}
+
+// journalPriority is the syslog priority journald expects for a level.
+func journalPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// journalField turns an attribute key into a journald field name, which may
+// only have upper case letters, digits and underscores.
+func journalField(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, key)
+	// fields starting with an underscore are reserved for journald itself
+	name = strings.TrimLeft(name, "_")
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "F_" + name
+	}
+	return name
+}
+
+// newJournalHandler logs to journald with its native protocol, so the
+// attributes end up as fields that journalctl can filter on.
+func newJournalHandler(level slog.Leveler) (slog.Handler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	emit := func(level slog.Level, msg string, attrs []slog.Attr) error {
+		var b bytes.Buffer
+		field := func(name, value string) {
+			if !strings.Contains(value, "\n") {
+				fmt.Fprintf(&b, "%s=%s\n", name, value)
+				return
+			}
+			b.WriteString(name + "\n")
+			binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+			b.WriteString(value + "\n")
+		}
+		field("MESSAGE", msg)
+		field("PRIORITY", strconv.Itoa(journalPriority(level)))
+		field("SYSLOG_IDENTIFIER", logIdentifier)
+		for _, a := range attrs {
+			field(journalField(a.Key), a.Value.String())
+		}
+		_, err := conn.Write(b.Bytes())
+		return err
+	}
+	return &emitHandler{level: level, emit: emit}, nil
+}
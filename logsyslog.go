@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"strings"
+)
+
+// newSyslogHandler logs to the local syslog daemon, or to address given like udp://host:514.
+func newSyslogHandler(level slog.Leveler, address string) (slog.Handler, error) {
+	network := ""
+	if address != "" {
+		var ok bool
+		if network, address, ok = strings.Cut(address, "://"); !ok {
+			return nil, fmt.Errorf("invalid syslog address %q, use like udp://host:514", address)
+		}
+	}
+	w, err := syslog.Dial(network, address, syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	emit := func(level slog.Level, msg string, attrs []slog.Attr) error {
+		line := logfmt(msg, attrs)
+		switch {
+		case level >= slog.LevelError:
+			return w.Err(line)
+		case level >= slog.LevelWarn:
+			return w.Warning(line)
+		case level >= slog.LevelInfo:
+			return w.Info(line)
+		}
+		return w.Debug(line)
+	}
+	return &emitHandler{level: level, emit: emit}, nil
+}
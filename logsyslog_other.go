@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+func newSyslogHandler(level slog.Leveler, address string) (slog.Handler, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lokiEntry is a log line about a device, pushed to Loki as JSON.
+type lokiEntry struct {
+	time   time.Time
+	device string
+	kind   string
+	line   map[string]string
+}
+
+var (
+	lokiURL       string
+	lokiLabels    string
+	lokiTenant    string
+	lokiBatchWait time.Duration
+
+	lokiEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_loki_entries_total",
+		Help: "Log lines pushed to Loki, labeled by result: success, failure or dropped when the queue was full.",
+	}, []string{"result"})
+
+	// nil unless -loki.url is set
+	lokiQueue chan lokiEntry
+	// parsed -loki.labels
+	lokiStaticLabels map[string]string
+)
+
+func init() {
+	flag.StringVar(&lokiURL, "loki.url", "", "Loki to push the events to as log lines, e.g. http://loki:3100 (disabled when empty)")
+	flag.StringVar(&lokiLabels, "loki.labels", "job=homekit-ratgdo-exporter", "Comma separated name=value labels added to the log streams pushed to Loki")
+	flag.StringVar(&lokiTenant, "loki.tenant", "", "Tenant ID sent to Loki as X-Scope-OrgID")
+	flag.DurationVar(&lokiBatchWait, "loki.batch-wait", time.Second, "Collect log lines this long before pushing them to Loki together")
+
+	prometheus.MustRegister(lokiEntries)
+	onEvent(func(e Event) {
+		pushLoki(lokiEntry{time: e.Time, device: e.Device, kind: e.Type, line: map[string]string{
+			"event": e.Name(), "from": e.From, "to": e.To, "displayName": targetDisplayName(e.Device),
+		}})
+	})
+}
+
+// pushLoki queues an entry for Loki. It never blocks, entries are dropped
+// when Loki can't keep up.
+func pushLoki(e lokiEntry) {
+	if lokiQueue == nil {
+		return
+	}
+	select {
+	case lokiQueue <- e:
+	default:
+		lokiEntries.WithLabelValues("dropped").Inc()
+	}
+}
+
+func startLoki() error {
+	u, err := url.Parse(lokiURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid -loki.url %q", lokiURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/loki/api/v1/push"
+	}
+	lokiURL = u.String()
+	lokiStaticLabels = map[string]string{}
+	for _, kv := range strings.Split(lokiLabels, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid -loki.labels entry %q, use name=value", kv)
+		}
+		lokiStaticLabels[name] = value
+	}
+	lokiQueue = make(chan lokiEntry, 1000)
+	slog.Info("Pushing events to Loki", "url", u.Redacted())
+	go runLoki()
+	return nil
+}
+
+// runLoki pushes the queued entries in batches.
+func runLoki() {
+	defer reportPanic()
+	for e := range lokiQueue {
+		batch := []lokiEntry{e}
+		timer := time.NewTimer(lokiBatchWait)
+	collect:
+		for {
+			select {
+			case e := <-lokiQueue:
+				batch = append(batch, e)
+			case <-timer.C:
+				break collect
+			}
+		}
+		result := "success"
+		if err := postLoki(batch); err != nil {
+			slog.Error("Error pushing to Loki", "entries", len(batch), "err", err)
+			result = "failure"
+		}
+		lokiEntries.WithLabelValues(result).Add(float64(len(batch)))
+	}
+}
+
+// postLoki pushes entries with one stream per device and kind of entry.
+func postLoki(batch []lokiEntry) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := map[string]*stream{}
+	var keys []string
+	for _, e := range batch {
+		key := e.device + "\x00" + e.kind
+		s, ok := streams[key]
+		if !ok {
+			labels := map[string]string{"location": targetLocation(e.device), "device": e.device, "type": e.kind}
+			for k, v := range lokiStaticLabels {
+				labels[k] = v
+			}
+			s = &stream{Stream: labels}
+			streams[key] = s
+			keys = append(keys, key)
+		}
+		line, err := json.Marshal(e.line)
+		if err != nil {
+			return err
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), string(line)})
+	}
+	sort.Strings(keys)
+	var body struct {
+		Streams []*stream `json:"streams"`
+	}
+	for _, k := range keys {
+		body.Streams = append(body.Streams, streams[k])
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, lokiURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "homekit-ratgdo-exporter/"+version)
+	if lokiTenant != "" {
+		req.Header.Set("X-Scope-OrgID", lokiTenant)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
-	"io/ioutil"
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/httptrace/otelhttptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Status struct {
@@ -23,6 +34,7 @@ type Status struct {
 	GatewayIP        string `json:"gatewayIP"`
 	MacAddress       string `json:"macAddress"`
 	WifiSSID         string `json:"wifiSSID"`
+	WifiRSSI         string `json:"wifiRSSI"`
 	GDOSecurityType  string `json:"GDOSecurityType"`
 	GarageDoorState  string `json:"garageDoorState"`
 	GarageLockState  string `json:"garageLockState"`
@@ -56,76 +68,119 @@ var (
 	minStack         *prometheus.GaugeVec
 	crashCount       *prometheus.GaugeVec
 	garageDoorState  *prometheus.GaugeVec
+	doorStatus       *prometheus.GaugeVec
 	deviceInfo       *prometheus.GaugeVec
-
-	requestCount *prometheus.CounterVec // new counter metric
-
-	jsonAddress string
-	port        string
-	location    string
-	mutex       sync.Mutex
+	doorOpenSeconds  *prometheus.GaugeVec
+	deviceUp         *prometheus.GaugeVec
+
+	requestCount      *prometheus.CounterVec // new counter metric
+	doorCycles        *prometheus.CounterVec
+	obstructionsTotal *prometheus.CounterVec
+	crashesTotal      *prometheus.CounterVec
+	closeReversals    *prometheus.CounterVec
+
+	jsonAddress  string
+	port         string
+	location     string
+	pollInterval time.Duration
+	pollJitter   float64
+	// guards what the devices share, see deviceState
+	mutex sync.Mutex
+
+	fetchConcurrency int
+	fetchTimeout     time.Duration
 )
 
 func init() {
 	upTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_up_time_seconds",
 		Help: "Uptime of the garage door in seconds.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	paired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_paired",
 		Help: "Indicates if the garage door is paired.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	garageLightOn = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_light_on",
 		Help: "Indicates if the garage light is on.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	garageMotion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_motion",
 		Help: "Indicates if there is motion detected in the garage.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	garageObstructed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_obstructed",
 		Help: "Indicates if the garage door is obstructed.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	passwordRequired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_password_required",
 		Help: "Indicates if a password is required.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	freeHeap = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_free_heap_bytes",
 		Help: "Free heap memory in bytes.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	minHeap = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_min_heap_bytes",
 		Help: "Minimum heap memory in bytes.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	minStack = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_min_stack_bytes",
 		Help: "Minimum stack memory in bytes.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	crashCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_crash_count",
 		Help: "Number of crashes.",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+	}, deviceLabelNames)
 
 	garageDoorState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_door_state",
-		Help: "The state of the garage door (0 = Closed, 1 = Open).",
-	}, []string{"location", "accessoryID", "deviceName", "localIP", "macAddress"})
+		Help: "The state of the garage door (0 = Closed, 1 = Open, Opening, Closing or Stopped, NaN when the firmware reports something else).",
+	}, deviceLabelNames)
+
+	doorStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_door_status",
+		Help: "1 for the state the garage door is in (closed, opening, open, closing, stopped or unknown), 0 for the others.",
+	}, []string{"location", "accessoryID", "deviceName", "displayName", "localIP", "macAddress", "state"})
 
 	deviceInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "homekit_ratgdo_info",
 		Help: "Garage door device info.",
-	}, []string{"location", "firmwareVersion", "subnetMask", "gatewayIP", "wifiSSID", "garageLockState", "GDOSecurityType"})
+	}, []string{"location", "displayName", "firmwareVersion", "subnetMask", "gatewayIP", "wifiSSID", "garageLockState", "GDOSecurityType"})
+
+	doorOpenSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_door_open_duration_seconds",
+		Help: "How long the garage door has been open, 0 when closed.",
+	}, deviceLabelNames)
+
+	doorCycles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_door_cycles_total",
+		Help: "Number of times the garage door closed after being open.",
+	}, deviceLabelNames)
+
+	obstructionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_obstructions_total",
+		Help: "Number of times the obstruction sensor triggered.",
+	}, deviceLabelNames)
+
+	crashesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_crashes_total",
+		Help: "Number of crashes, unlike homekit_ratgdo_crash_count this doesn't start over when the crash log is cleared.",
+	}, deviceLabelNames)
+
+	closeReversals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_close_reversals_total",
+		Help: "Number of times the garage door reversed or stopped while closing because it was obstructed.",
+	}, deviceLabelNames)
 
 	requestCount = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -135,12 +190,24 @@ func init() {
 		[]string{"status_code_class"},
 	)
 
-	flag.StringVar(&jsonAddress, "json-address", "http://ratgdo/status.json", "The address of the JSON endpoint")
-	flag.StringVar(&port, "port", "8080", "The port to expose metrics on")
-	flag.StringVar(&location, "location", "home", "The location label for the metrics")
-	flag.Parse()
+	flag.StringVar(&jsonAddress, "json-address", "http://ratgdo/status.json", "The address of the JSON endpoint, or a comma separated list of [name=]address for several devices")
+	flag.StringVar(&port, "port", "8080", "The port to expose metrics on (deprecated, use -web.listen-address :8080)")
+	flag.StringVar(&location, "location", "home", "The location label for the metrics of devices without their own in the config file")
+	flag.DurationVar(&pollInterval, "poll-interval", 0, "Poll the JSON endpoint in the background at this interval (0 disables polling)")
+	flag.Float64Var(&pollJitter, "poll-jitter", 0.1, "Shift every poll randomly by up to this fraction of -poll-interval")
+	flag.IntVar(&fetchConcurrency, "fetch.concurrency", 4, "Fetch at most this many devices at a time")
+	flag.DurationVar(&fetchTimeout, "fetch.timeout", 10*time.Second, "Give up fetching a device after this long, keep it below the Prometheus scrape timeout")
 
 	// Register all metrics including new requestCount
+	deviceUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_up",
+		Help: "Whether the last fetch of the device's status succeeded.",
+	}, []string{"location", "device", "displayName"})
+
+	onUpdate(func(d *deviceState) {
+		deviceUp.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name)).Set(boolToFloat(d.Online))
+	})
+
 	prometheus.MustRegister(upTime)
 	prometheus.MustRegister(paired)
 	prometheus.MustRegister(garageLightOn)
@@ -152,8 +219,15 @@ func init() {
 	prometheus.MustRegister(minStack)
 	prometheus.MustRegister(crashCount)
 	prometheus.MustRegister(garageDoorState)
+	prometheus.MustRegister(doorStatus)
 	prometheus.MustRegister(deviceInfo)
+	prometheus.MustRegister(doorOpenSeconds)
+	prometheus.MustRegister(deviceUp)
 	prometheus.MustRegister(requestCount)
+	prometheus.MustRegister(doorCycles)
+	prometheus.MustRegister(obstructionsTotal)
+	prometheus.MustRegister(crashesTotal)
+	prometheus.MustRegister(closeReversals)
 
 	// Pre-allocate request count labels
 	requestCount.WithLabelValues("2xx")
@@ -162,73 +236,163 @@ func init() {
 	requestCount.WithLabelValues("5xx")
 }
 
-func fetchData() (int, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	resp, err := http.Get(jsonAddress)
-	if err != nil {
-		log.Printf("Error fetching data: %v", err)
+func fetchData(ctx context.Context, t Target) (int, error) {
+	ctx, span := tracer.Start(ctx, "fetch "+t.Name, trace.WithAttributes(attribute.String("device", t.Name), attribute.String("url.full", t.URL)))
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	fetchCtx, cancel := context.WithDeadline(ctx, fetchDeadline(ctx))
+	defer cancel()
+	// the DNS lookup, connection and response each get a span
+	traced := httptrace.WithClientTrace(fetchCtx, otelhttptrace.NewClientTrace(ctx))
+	d := getDevice(t.Name)
+	fail := func(msg string, err error) (int, error) {
+		// nobody wants the result anymore, that says nothing about the device
+		if ctx.Err() != nil {
+			slog.Debug("Fetch cancelled", "device", t.Name, "err", err)
+			cancelledFetches.WithLabelValues(t.Name).Inc()
+			return 0, ctx.Err()
+		}
+		slog.Error(msg, "device", t.Name, "url", t.URL, "err", err)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		recordFailure(d, start, err)
 		return 0, err
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return 0, err
+	var body []byte
+	code := http.StatusOK
+	if t.hap() {
+		if body, err = fetchHAP(traced, t); err != nil {
+			return fail("Error fetching data", err)
+		}
+	} else {
+		req, err := t.newRequest(traced, http.MethodGet, t.URL, nil)
+		if err != nil {
+			return fail("Error fetching data", err)
+		}
+		// newer firmwares compress their status, which saves airtime
+		if req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		resp, err := t.do(http.DefaultClient, req)
+		if err != nil {
+			return fail("Error fetching data", err)
+		}
+		defer resp.Body.Close()
+		code = resp.StatusCode
+		countRequest(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+		_, readSpan := tracer.Start(ctx, "read body")
+		body, err = readDeviceBody(t.Name, resp)
+		readSpan.SetAttributes(attribute.Int("bytes", len(body)))
+		endSpan(readSpan, err)
+		if err != nil {
+			return fail("Error reading response body", err)
+		}
 	}
 
+	_, decodeSpan := tracer.Start(ctx, "decode")
 	var status Status
 	err = json.Unmarshal(body, &status)
+	endSpan(decodeSpan, err)
 	if err != nil {
-		log.Printf("Error unmarshalling JSON: %v", err)
-		return 0, err
+		return fail("Error unmarshalling JSON", err)
 	}
 
-	upTime.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(float64(status.UpTime))
-	paired.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(boolToFloat(status.Paired))
-	garageLightOn.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(boolToFloat(status.GarageLightOn))
-	garageMotion.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(boolToFloat(status.GarageMotion))
-	garageObstructed.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(boolToFloat(status.GarageObstructed))
-	passwordRequired.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(boolToFloat(status.PasswordRequired))
-	freeHeap.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(float64(status.FreeHeap))
-	minHeap.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(float64(status.MinHeap))
-	minStack.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(float64(status.MinStack))
-	crashCount.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(float64(status.CrashCount))
-
-	if status.GarageDoorState == "Closed" {
-		garageDoorState.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(0)
-	} else if status.GarageDoorState == "Open" {
-		garageDoorState.WithLabelValues(location, status.AccessoryID, status.DeviceName, status.LocalIP, status.MacAddress).Set(1)
-	}
-
-	deviceInfo.With(prometheus.Labels{
-		"location":        location,
+	_, lockSpan := tracer.Start(ctx, "wait for lock")
+	d.mu.Lock()
+	lockSpan.End()
+	defer d.mu.Unlock()
+
+	recordStatus(d, start, status)
+	d.RawStatus = body
+	d.labels = deviceLabels(t.Name, status)
+	slog.Debug("Fetched status", "device", t.Name, "duration", d.FetchDuration, "door", status.GarageDoorState)
+
+	upTime.WithLabelValues(deviceLabels(t.Name, status)...).Set(float64(status.UpTime))
+	paired.WithLabelValues(deviceLabels(t.Name, status)...).Set(boolToFloat(status.Paired))
+	garageLightOn.WithLabelValues(deviceLabels(t.Name, status)...).Set(boolToFloat(status.GarageLightOn))
+	garageMotion.WithLabelValues(deviceLabels(t.Name, status)...).Set(boolToFloat(status.GarageMotion))
+	garageObstructed.WithLabelValues(deviceLabels(t.Name, status)...).Set(boolToFloat(status.GarageObstructed))
+	passwordRequired.WithLabelValues(deviceLabels(t.Name, status)...).Set(boolToFloat(status.PasswordRequired))
+	freeHeap.WithLabelValues(deviceLabels(t.Name, status)...).Set(float64(status.FreeHeap))
+	minHeap.WithLabelValues(deviceLabels(t.Name, status)...).Set(float64(status.MinHeap))
+	minStack.WithLabelValues(deviceLabels(t.Name, status)...).Set(float64(status.MinStack))
+	crashCount.WithLabelValues(deviceLabels(t.Name, status)...).Set(float64(status.CrashCount))
+	doorOpenSeconds.WithLabelValues(deviceLabels(t.Name, status)...).Set(d.openDuration().Seconds())
+
+	setDoorState(t.Name, deviceLabels(t.Name, status), status.GarageDoorState)
+
+	info := prometheus.Labels{
+		"location":        targetLocation(t.Name),
+		"displayName":     targetDisplayName(t.Name),
 		"firmwareVersion": status.FirmwareVersion,
 		"subnetMask":      status.SubnetMask,
 		"gatewayIP":       status.GatewayIP,
 		"wifiSSID":        status.WifiSSID,
 		"garageLockState": status.GarageLockState,
 		"GDOSecurityType": status.GDOSecurityType,
-	}).Set(1)
+	}
+	setDeviceInfo(d, info)
 
-	return resp.StatusCode, nil
+	return code, nil
 }
 
-func boolToFloat(b bool) float64 {
-	if b {
-		return 1
+var (
+	infoMutex sync.Mutex
+	// how many devices have each homekit_ratgdo_info series, by its labels; guarded by infoMutex
+	infoDevices = map[string]int{}
+)
+
+// setDeviceInfo sets the homekit_ratgdo_info series of a device, or deletes
+// it for nil labels. After a firmware update or a new SSID only the new one
+// is current, so the old one is deleted unless another device still has it.
+func setDeviceInfo(d *deviceState, labels prometheus.Labels) {
+	if d.infoLabels != nil && maps.Equal(d.infoLabels, labels) {
+		return
 	}
-	return 0
+	key := func(l prometheus.Labels) string { return fmt.Sprint(map[string]string(l)) }
+	infoMutex.Lock()
+	defer infoMutex.Unlock()
+	if d.infoLabels != nil {
+		old := key(d.infoLabels)
+		if infoDevices[old]--; infoDevices[old] <= 0 {
+			delete(infoDevices, old)
+			deviceInfo.Delete(d.infoLabels)
+		}
+	}
+	d.infoLabels = labels
+	if labels == nil {
+		return
+	}
+	infoDevices[key(labels)]++
+	deviceInfo.With(labels).Set(1)
 }
 
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	statusCode, err := fetchData()
-	if err != nil {
-		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+// doorStates are the door states of the firmware with their door_state value.
+var doorStates = map[string]float64{"Closed": 0, "Opening": 1, "Open": 1, "Closing": 1, "Stopped": 1}
+
+// unknown door states that were logged already
+var loggedDoorStates sync.Map
+
+func setDoorState(device string, labels []string, state string) {
+	value, known := doorStates[state]
+	if !known {
+		value = math.NaN()
+		if _, logged := loggedDoorStates.LoadOrStore(state, true); !logged {
+			slog.Warn("Unknown door state", "device", device, "state", state)
+		}
 	}
+	garageDoorState.WithLabelValues(labels...).Set(value)
+	for _, s := range []string{"Closed", "Opening", "Open", "Closing", "Stopped", "Unknown"} {
+		current := s == state || s == "Unknown" && !known
+		doorStatus.WithLabelValues(append(labels, strings.ToLower(s))...).Set(boolToFloat(current))
+	}
+}
 
+func countRequest(statusCode int) {
 	switch {
 	case statusCode >= 200 && statusCode < 300:
 		requestCount.WithLabelValues("2xx").Inc()
@@ -239,12 +403,253 @@ func metricsHandler(w http.ResponseWriter, r *http.Request) {
 	case statusCode >= 500:
 		requestCount.WithLabelValues("5xx").Inc()
 	}
+}
 
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collect fetches every target, -fetch.concurrency at a time, and returns
+// the last error.
+func collect(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "collect")
+	defer span.End()
+	var (
+		wg       sync.WaitGroup
+		errMutex sync.Mutex
+		err      error
+	)
+	slots := make(chan struct{}, max(fetchConcurrency, 1))
+	for _, t := range currentTargets() {
+		if maintenanceMode(t.Name) == maintenancePause {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(t Target) {
+			defer func() { <-slots; wg.Done() }()
+			if fetchErr := fetchOrCached(ctx, t); fetchErr != nil {
+				errMutex.Lock()
+				err = fetchErr
+				errMutex.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return err
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	// a device that couldn't be fetched shows in homekit_ratgdo_up, the
+	// rest of the metrics are still worth having. On standby, only the
+	// leader talks to the devices.
+	// with tenants, the token tells whose series to serve, so one is required
+	if len(currentConfig().Tenants) > 0 {
+		if _, ok := authorize(w, r, scopeRead, ""); !ok {
+			return
+		}
+	}
+	if isLeader() {
+		heartbeat(collect(r.Context()))
+	}
+	if visible := visibleDevices(r); visible != nil {
+		visibleMetrics(visible).ServeHTTP(w, r)
+		return
+	}
 	promhttp.Handler().ServeHTTP(w, r)
 }
 
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [command] [flags]
+
+Commands:
+  serve       Run the exporter (default)
+  tui         Show a live terminal dashboard of the devices
+  dashboard   Print a Grafana dashboard for the exporter's metrics
+  rules       Print Prometheus alerting and recording rules for the exporter's metrics
+  export      Print the events of a running exporter as CSV
+  influx      Print the metrics of one collection in Influx line protocol, for Telegraf's exec input
+  check       Check a device like a Nagios or Icinga plugin
+  pair        Pair with a hap:// device: pair <device> <setup code>
+  ctl         Show the status and events of a running exporter and control its devices (also run as ratgdoctl)
+  version     Print the version
+  hash-password
+              Print the bcrypt hash of a password read from stdin, for basicAuthUsers
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
 func main() {
-	http.HandleFunc("/metrics", metricsHandler)
-	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	defer reportPanic()
+	command := "serve"
+	args := os.Args[1:]
+	if filepath.Base(os.Args[0]) == "ratgdoctl" {
+		command = "ctl"
+	} else if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+	if err := setupLogging(os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if showVersion || command == "version" {
+		fmt.Println(versionString())
+		return
+	}
+
+	if eventLogSize < 0 {
+		fatal("-events.max can't be negative", "events_max", eventLogSize)
+	}
+	if configFile != "" && configURL != "" {
+		fatal("-config and -config.url can't be used together")
+	}
+	if configFile != "" || configURL != "" {
+		c, err := readConfig()
+		if err != nil {
+			fatal("Error loading config", "from", configSource(), "err", err)
+		}
+		configPtr.Store(c)
+	}
+	if _, err := staticTargets(currentConfig()); err != nil {
+		fatal("Error parsing the devices", "err", err)
+	}
+	if err := updateTargets(); err != nil {
+		fatal("Error sharding devices", "err", err)
+	}
+	if shardTotal != 1 {
+		slog.Info("Watching a shard of the devices", "shard", shardIndex, "shards", shardTotal, "devices", len(currentTargets()))
+	}
+
+	switch command {
+	case "serve":
+		serve()
+	case "tui":
+		runTUI()
+	case "dashboard":
+		runDashboard()
+	case "rules":
+		runRules()
+	case "export":
+		runExport()
+	case "ctl":
+		runCtl(flag.Args())
+	case "influx":
+		runInflux()
+	case "check":
+		runCheck(flag.Args())
+	case "pair":
+		runPair(flag.Args())
+	case "hash-password":
+		runHashPassword()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+func serve() {
+	if err := startSentry(); err != nil {
+		fatal("Error setting up Sentry", "err", err)
+	}
+	if mqttBroker != "" {
+		if err := startMQTT(); err != nil {
+			fatal("Error connecting to MQTT broker", "broker", mqttBroker, "err", err)
+		}
+	}
+	if lokiURL != "" {
+		if err := startLoki(); err != nil {
+			fatal("Error setting up Loki", "err", err)
+		}
+	}
+	if vmURL != "" {
+		if err := startVictoriaMetrics(); err != nil {
+			fatal("Error setting up VictoriaMetrics", "err", err)
+		}
+	}
+	if snmpListenAddress != "" {
+		if err := startSNMP(); err != nil {
+			fatal("Error starting SNMP agent", "err", err)
+		}
+	}
+	if err := openStorage(); err != nil {
+		fatal("Error opening storage", "path", storagePath, "err", err)
+	}
+	if err := loadCounters(); err != nil {
+		fatal("Error loading counters", "err", err)
+	}
+	if err := loadAudit(); err != nil {
+		fatal("Error reading audit log", "file", auditFile, "err", err)
+	}
+	if err := startHA(); err != nil {
+		fatal("Error setting up high availability", "err", err)
+	}
+	if pollJitter < 0 || pollJitter >= 1 {
+		fatal("-poll-jitter has to be at least 0 and less than 1", "poll_jitter", pollJitter)
+	}
+	// devices may have their own interval without -poll-interval
+	poll()
+	if healthcheckURL != "" {
+		go pingPolls()
+	}
+	startWarmup()
+	go scheduleReports()
+	if firmwareCheckInterval > 0 {
+		go watchFirmware()
+	}
+	if err := startTracing(); err != nil {
+		fatal("Error setting up tracing", "err", err)
+	}
+	go reloadOnHUP()
+	if configURL != "" && configURLInterval > 0 {
+		go watchConfigURL()
+	}
+	if kubernetesDiscovery {
+		go watchKubernetes()
+	}
+	if pprofAddress != "" {
+		if err := startPprof(); err != nil {
+			fatal("Error starting pprof", "err", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, instrument(pattern, h))
+	}
+	handle("/metrics", honorScrapeTimeout(rateLimitScrapes(limitScrapes(metricsHandler))))
+	handle("/api/v1/status", compress(readAccess(statusHandler)))
+	handle("/api/v1/status/", compress(readAccess(statusHandler)))
+	handle("/api/v1/targets", compress(readAccess(targetsHandler)))
+	handle("/api/v1/devices/", controlHandler)
+	handle("/api/v1/history/", compress(readAccess(historyHandler)))
+	handle("/api/v1/reports", compress(readAccess(reportsHandler)))
+	handle("/api/v1/audit", compress(auditHandler))
+	handle("/api/v1/events", compress(readAccess(eventsHandler)))
+	handle("/api/v1/events.csv", compress(readAccess(eventsCSVHandler)))
+	handle("/api/v1/events/daily", compress(readAccess(dailyEventsHandler)))
+	handle("/stream", readAccess(streamHandler))
+	handle("/ws", readAccess(wsHandler))
+	handle("/dashboard.json", compress(readAccess(dashboardHandler)))
+	handle("/-/reload", lifecycleHandler)
+	handle("/-/quit", lifecycleHandler)
+	handle("/healthz", healthzHandler)
+	handle("/readyz", readyzHandler)
+	handle("/proxy/", compress(readAccess(proxyHandler)))
+	handle("/", compress(uiHandler))
+	if err := listen(mux); err != nil {
+		fatal("Error serving HTTP", "err", err)
+	}
+	releaseLease()
+	if db != nil {
+		db.Close()
+	}
+	slog.Info("Stopped")
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Maintenance modes of a device. In both, alerts about it being unreachable,
+// its notifications and watchdog reboots are suppressed; paused devices also
+// aren't polled.
+const (
+	maintenanceOff   = "off"
+	maintenanceOn    = "on"
+	maintenancePause = "pause"
+)
+
+var (
+	maintenanceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_maintenance",
+		Help: "1 if the device is in maintenance mode, 2 if its polling is paused as well.",
+	}, []string{"location", "device", "displayName"})
+
+	maintenanceMutex sync.Mutex
+	// set through the API, overriding the config until the exporter restarts; guarded by maintenanceMutex
+	maintenanceModes = map[string]string{}
+)
+
+func init() {
+	prometheus.MustRegister(maintenanceGauge)
+}
+
+func validMaintenance(mode string) bool {
+	switch mode {
+	case maintenanceOff, maintenanceOn, maintenancePause:
+		return true
+	}
+	return false
+}
+
+// maintenanceMode is the maintenance mode of a device, off unless it was set
+// through the API or in the config.
+func maintenanceMode(name string) string {
+	maintenanceMutex.Lock()
+	mode, ok := maintenanceModes[name]
+	maintenanceMutex.Unlock()
+	if ok {
+		return mode
+	}
+	if mode = currentConfig().Targets[name].Maintenance; mode != "" {
+		return mode
+	}
+	return maintenanceOff
+}
+
+func inMaintenance(name string) bool {
+	return maintenanceMode(name) != maintenanceOff
+}
+
+// setMaintenanceMetrics exports the maintenance mode of the targets.
+func setMaintenanceMetrics(ts []Target) {
+	for _, t := range ts {
+		var v float64
+		switch maintenanceMode(t.Name) {
+		case maintenanceOn:
+			v = 1
+		case maintenancePause:
+			v = 2
+		}
+		maintenanceGauge.WithLabelValues(targetLocation(t.Name), t.Name, targetDisplayName(t.Name)).Set(v)
+	}
+}
+
+// maintenanceHandler serves POST /api/v1/devices/{device}/maintenance with an
+// action of on, pause or off. It doesn't contact the device.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request, name, action string, entry auditEntry) {
+	if !validMaintenance(action) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown action %q, use one of %s, %s, %s", action, maintenanceOff, maintenanceOn, maintenancePause))
+		return
+	}
+	token, ok := authorize(w, r, scopeControl, name)
+	if !ok {
+		entry.Actor, entry.Result = "anonymous", "denied"
+		if t := authenticate(r); t != nil {
+			entry.Actor = t.Name
+		}
+		recordAudit(entry)
+		return
+	}
+	entry.Actor = token.Name
+	t, ok := findTarget(name)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown device "+name)
+		return
+	}
+
+	maintenanceMutex.Lock()
+	maintenanceModes[name] = action
+	maintenanceMutex.Unlock()
+	setMaintenanceMetrics([]Target{t})
+	slog.Info("Maintenance mode changed", "device", name, "mode", action, "token", token.Name, "remote", r.RemoteAddr)
+	entry.Result = "success"
+	recordAudit(entry)
+	writeJSON(w, http.StatusOK, map[string]string{"device": name, "control": "maintenance", "action": action, "result": "ok"})
+}
+
+// maintenanceSuppresses reports whether a notification is held back because
+// its device is in maintenance. Reports still go out.
+func maintenanceSuppresses(n Notification) bool {
+	return n.Device != "" && !strings.HasPrefix(n.Event, "report.") && inMaintenance(n.Device)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// motionWatch is what a device looked like at its last fetch, and the motion
+// that is waiting for door or light activity to explain it.
+type motionWatch struct {
+	door       string
+	light      bool
+	motion     bool
+	doorChange time.Time
+	pending    time.Time
+}
+
+var (
+	motionQuietFor time.Duration
+	motionWindow   time.Duration
+
+	unexplainedMotion = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_unexplained_motion_total",
+		Help: "Motion while the door had been closed for -motion.quiet-for, without door or light activity within -motion.window.",
+	}, []string{"location", "device", "displayName"})
+
+	// per device; guarded by mutex
+	motionWatches = map[string]*motionWatch{}
+)
+
+func init() {
+	flag.DurationVar(&motionQuietFor, "motion.quiet-for", time.Hour, "How long the door has to have been closed for motion to count as unexplained")
+	flag.DurationVar(&motionWindow, "motion.window", 5*time.Minute, "How long after motion the door or light may still be used to explain it")
+
+	prometheus.MustRegister(unexplainedMotion)
+	onUpdate(watchMotion)
+}
+
+// watchMotion counts motion in a quiet garage that nobody follows up by
+// opening the door or switching the light, like someone who shouldn't be there.
+func watchMotion(d *deviceState) {
+	if !d.Online {
+		return
+	}
+	now := time.Now()
+	s := d.Status
+	w, ok := motionWatches[d.Name]
+	if !ok {
+		// nobody knows how long the door has been closed before the exporter started
+		w = &motionWatch{door: s.GarageDoorState, light: s.GarageLightOn, motion: s.GarageMotion, doorChange: now}
+		motionWatches[d.Name] = w
+		unexplainedMotion.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name))
+		return
+	}
+	if s.GarageDoorState != w.door {
+		w.doorChange = now
+		w.pending = time.Time{}
+	}
+	if s.GarageLightOn != w.light {
+		w.pending = time.Time{}
+	}
+	if s.GarageMotion && !w.motion && w.pending.IsZero() && s.GarageDoorState == "Closed" && now.Sub(w.doorChange) >= motionQuietFor {
+		w.pending = now
+	}
+	if !w.pending.IsZero() && now.Sub(w.pending) >= motionWindow {
+		unexplainedMotion.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name)).Inc()
+		w.pending = time.Time{}
+	}
+	w.door, w.light, w.motion = s.GarageDoorState, s.GarageLightOn, s.GarageMotion
+}
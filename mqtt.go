@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mqttBroker      string
+	mqttTopicPrefix string
+	mqttClientID    string
+	mqttUsername    string
+	mqttPassword    string
+
+	mqttClient mqtt.Client
+	// last payload published per topic, so only changes are sent
+	mqttPublished = map[string]string{}
+)
+
+func init() {
+	flag.StringVar(&mqttBroker, "mqtt.broker", "", "MQTT broker to publish state to, e.g. tcp://localhost:1883 (disabled when empty)")
+	flag.StringVar(&mqttTopicPrefix, "mqtt.topic-prefix", "homekit-ratgdo", "Prefix of the MQTT topic tree")
+	flag.StringVar(&mqttClientID, "mqtt.client-id", "homekit-ratgdo-exporter", "MQTT client ID")
+	flag.StringVar(&mqttUsername, "mqtt.username", "", "MQTT username")
+	flag.StringVar(&mqttPassword, "mqtt.password", "", "MQTT password")
+}
+
+func mqttTopic(parts ...string) string {
+	return strings.Join(append([]string{mqttTopicPrefix}, parts...), "/")
+}
+
+func startMQTT() error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttBroker).
+		SetClientID(mqttClientID).
+		SetUsername(mqttUsername).
+		SetPassword(mqttPassword).
+		SetAutoReconnect(true).
+		SetWill(mqttTopic("status"), "offline", 1, true)
+
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		slog.Info("Connected to MQTT broker", "broker", mqttBroker)
+		c.Publish(mqttTopic("status"), 1, true, "online")
+		subscribeHomeAssistant(c)
+
+		// the broker may have lost retained state, publish everything again
+		go func() {
+			mutex.Lock()
+			mqttPublished = map[string]string{}
+			mutex.Unlock()
+			for _, d := range allDevices() {
+				d.mu.Lock()
+				mutex.Lock()
+				publishDevice(d)
+				mutex.Unlock()
+				d.mu.Unlock()
+			}
+		}()
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		slog.Warn("Lost connection to MQTT broker", "broker", mqttBroker, "err", err)
+	})
+
+	mqttClient = mqtt.NewClient(opts)
+	token := mqttClient.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	onUpdate(publishDevice)
+	return nil
+}
+
+func publishDevice(d *deviceState) {
+	if d.Online {
+		mqttPublish(mqttTopic(d.Name, "availability"), "online")
+	} else {
+		mqttPublish(mqttTopic(d.Name, "availability"), "offline")
+	}
+	if !d.Seen {
+		return
+	}
+
+	mqttPublish(mqttTopic(d.Name, "door"), strings.ToLower(d.Status.GarageDoorState))
+	mqttPublish(mqttTopic(d.Name, "light"), onOff(d.Status.GarageLightOn))
+	mqttPublish(mqttTopic(d.Name, "motion"), onOff(d.Status.GarageMotion))
+	mqttPublish(mqttTopic(d.Name, "obstruction"), onOff(d.Status.GarageObstructed))
+	mqttPublish(mqttTopic(d.Name, "open_duration"), fmt.Sprintf("%.0f", d.openDuration().Seconds()))
+	mqttPublish(mqttTopic(d.Name, "cycles"), fmt.Sprint(d.Cycles))
+
+	publishDiscovery(d)
+}
+
+func mqttPublish(topic, payload string) {
+	if mqttPublished[topic] == payload {
+		return
+	}
+	if !mqttClient.IsConnectionOpen() {
+		return
+	}
+	mqttClient.Publish(topic, 1, true, payload)
+	mqttPublished[topic] = payload
+}
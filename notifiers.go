@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func checkResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// ntfyNotifier publishes to an ntfy topic, see https://docs.ntfy.sh/publish/.
+type ntfyNotifier struct {
+	c *NotifierConfig
+}
+
+func (n ntfyNotifier) notify(msg Notification) error {
+	req, err := http.NewRequest(http.MethodPost, n.c.URL, strings.NewReader(msg.Message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Tags", "garage")
+	switch msg.Severity {
+	case severityCritical:
+		req.Header.Set("Priority", "5")
+	case severityWarning:
+		req.Header.Set("Priority", "4")
+	}
+	if n.c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.c.Token)
+	} else if n.c.Username != "" {
+		req.SetBasicAuth(n.c.Username, n.c.Password)
+	}
+	return checkResponse(notifyClient.Do(req))
+}
+
+// pushoverNotifier sends Pushover messages, see https://pushover.net/api.
+type pushoverNotifier struct {
+	c *NotifierConfig
+}
+
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+func (n pushoverNotifier) notify(msg Notification) error {
+	form := url.Values{
+		"token":     {n.c.Token},
+		"user":      {n.c.User},
+		"title":     {msg.Title},
+		"message":   {msg.Message},
+		"timestamp": {fmt.Sprint(msg.Time.Unix())},
+	}
+	if n.c.Device != "" {
+		form.Set("device", n.c.Device)
+	}
+	if msg.Severity != severityInfo {
+		form.Set("priority", "1")
+	}
+	endpoint := pushoverURL
+	if n.c.URL != "" {
+		endpoint = n.c.URL
+	}
+	return checkResponse(notifyClient.PostForm(endpoint, form))
+}
+
+func postJSON(endpoint string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return checkResponse(notifyClient.Post(endpoint, "application/json", bytes.NewReader(body)))
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	c *NotifierConfig
+}
+
+func (n slackNotifier) notify(msg Notification) error {
+	return postJSON(n.c.URL, map[string]string{"text": fmt.Sprintf("*%s*: %s", msg.Title, msg.Message)})
+}
+
+// discordNotifier posts to a Discord channel webhook.
+type discordNotifier struct {
+	c *NotifierConfig
+}
+
+func (n discordNotifier) notify(msg Notification) error {
+	payload := map[string]string{"content": fmt.Sprintf("**%s**: %s", msg.Title, msg.Message)}
+	if n.c.Username != "" {
+		payload["username"] = n.c.Username
+	}
+	return postJSON(n.c.URL, payload)
+}
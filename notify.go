@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Notification is a human readable message for the configured notifiers.
+type Notification struct {
+	Event    string
+	Device   string
+	Title    string
+	Message  string
+	Severity string
+	Time     time.Time
+}
+
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+var severityRank = map[string]int{severityInfo: 0, severityWarning: 1, severityCritical: 2}
+
+func validSeverity(s string) error {
+	if _, ok := severityRank[s]; !ok {
+		return fmt.Errorf("unknown severity %q", s)
+	}
+	return nil
+}
+
+type notifier interface {
+	notify(n Notification) error
+}
+
+// NotifierConfig configures one notification service. Which fields are used depends on Type.
+type NotifierConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Events selects the events to notify about by name, e.g. "door.open" or "door.left_open".
+	Events []string `json:"events"`
+	// Severities selects the severities to notify about, all when empty.
+	Severities []string `json:"severities"`
+	// During QuietHours only notifications of at least QuietSeverity (default critical) are sent.
+	// Falls back to the top-level quietHours when not set.
+	QuietHours    []timeWindow `json:"quietHours"`
+	QuietSeverity string       `json:"quietSeverity"`
+	// Devices overrides the routing above per device name.
+	Devices map[string]*NotifierRoute `json:"devices"`
+
+	URL      string `json:"url"`
+	Token    string `json:"token"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Device   string `json:"device"`
+
+	// email
+	Host    string   `json:"host"`
+	Port    int      `json:"port"`
+	TLS     string   `json:"tls"`
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+
+	// Templates override the message per event name or pattern, executed with the Notification.
+	Templates map[string]string `json:"templates"`
+	// RateLimit drops notifications beyond this many per RatePeriod.
+	RateLimit  int      `json:"rateLimit"`
+	RatePeriod duration `json:"ratePeriod"`
+
+	notifier  notifier
+	route     NotifierRoute
+	templates map[string]*template.Template
+	patterns  []string
+	rateMutex sync.Mutex
+	sent      []time.Time
+}
+
+// NotifierRoute decides which notifications a notifier gets. Fields left out of a
+// per-device override are inherited from the notifier.
+type NotifierRoute struct {
+	Disabled      bool         `json:"disabled"`
+	Events        []string     `json:"events"`
+	Severities    []string     `json:"severities"`
+	QuietHours    []timeWindow `json:"quietHours"`
+	QuietSeverity string       `json:"quietSeverity"`
+}
+
+var (
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_notifications_total",
+		Help: "Notifications sent, labeled by notifier and result (success, failure, rate_limited or suppressed).",
+	}, []string{"notifier", "result"})
+
+	// devices that were already notified about being left open; guarded by mutex
+	leftOpenNotified = map[string]bool{}
+)
+
+func init() {
+	prometheus.MustRegister(notificationsSent)
+
+	onEvent(func(e Event) {
+		notify(Notification{
+			Event:    e.Name(),
+			Device:   e.Device,
+			Title:    displayName(e.Device),
+			Message:  eventMessage(e),
+			Severity: eventSeverity(e.Name()),
+			Time:     e.Time,
+		})
+	})
+
+	onUpdate(func(d *deviceState) {
+		after := time.Duration(currentConfig().DoorLeftOpenAfter)
+		if after <= 0 || d.OpenSince.IsZero() {
+			delete(leftOpenNotified, d.Name)
+			return
+		}
+		open := d.openDuration()
+		if open < after || leftOpenNotified[d.Name] {
+			return
+		}
+		leftOpenNotified[d.Name] = true
+		notify(Notification{
+			Event:    "door.left_open",
+			Device:   d.Name,
+			Title:    displayName(d.Name),
+			Message:  fmt.Sprintf("Door has been open for %s", humanDuration(open)),
+			Severity: severityWarning,
+			Time:     time.Now(),
+		})
+	})
+}
+
+func (n *NotifierConfig) validate(quietHours []timeWindow) error {
+	if n.Name == "" {
+		n.Name = n.Type
+	}
+	if n.QuietHours == nil {
+		n.QuietHours = quietHours
+	}
+	if n.QuietSeverity == "" {
+		n.QuietSeverity = severityCritical
+	}
+	n.route = NotifierRoute{
+		Events:        n.Events,
+		Severities:    n.Severities,
+		QuietHours:    n.QuietHours,
+		QuietSeverity: n.QuietSeverity,
+	}
+	if err := n.route.validate(); err != nil {
+		return err
+	}
+	for device, r := range n.Devices {
+		if r == nil {
+			return fmt.Errorf("device %s: empty route", device)
+		}
+		if r.Events == nil {
+			r.Events = n.Events
+		}
+		if r.Severities == nil {
+			r.Severities = n.Severities
+		}
+		if r.QuietHours == nil {
+			r.QuietHours = n.QuietHours
+		}
+		if r.QuietSeverity == "" {
+			r.QuietSeverity = n.QuietSeverity
+		}
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("device %s: %v", device, err)
+		}
+	}
+	n.templates = map[string]*template.Template{}
+	for pattern, text := range n.Templates {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid template pattern %q", pattern)
+		}
+		t, err := template.New(pattern).Parse(text)
+		if err != nil {
+			return err
+		}
+		n.templates[pattern] = t
+		n.patterns = append(n.patterns, pattern)
+	}
+	// the longest, most specific, pattern wins
+	sort.Slice(n.patterns, func(i, j int) bool { return len(n.patterns[i]) > len(n.patterns[j]) })
+	if n.RateLimit > 0 && n.RatePeriod <= 0 {
+		n.RatePeriod = duration(time.Hour)
+	}
+
+	switch n.Type {
+	case "ntfy":
+		if n.URL == "" {
+			return errors.New("ntfy needs the topic url")
+		}
+		n.notifier = ntfyNotifier{n}
+	case "pushover":
+		if n.Token == "" || n.User == "" {
+			return errors.New("pushover needs token and user")
+		}
+		n.notifier = pushoverNotifier{n}
+	case "slack":
+		if n.URL == "" {
+			return errors.New("slack needs the incoming webhook url")
+		}
+		n.notifier = slackNotifier{n}
+	case "discord":
+		if n.URL == "" {
+			return errors.New("discord needs the webhook url")
+		}
+		n.notifier = discordNotifier{n}
+	case "email":
+		email, err := newEmailNotifier(n)
+		if err != nil {
+			return err
+		}
+		n.notifier = email
+	default:
+		return fmt.Errorf("unknown notifier type %q", n.Type)
+	}
+	return nil
+}
+
+func (r *NotifierRoute) validate() error {
+	for _, pattern := range r.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid event pattern %q", pattern)
+		}
+	}
+	for _, s := range r.Severities {
+		if err := validSeverity(s); err != nil {
+			return err
+		}
+	}
+	return validSeverity(r.QuietSeverity)
+}
+
+func (r *NotifierRoute) wants(msg Notification) bool {
+	if r.Disabled {
+		return false
+	}
+	if len(r.Events) > 0 {
+		matched := false
+		for _, pattern := range r.Events {
+			if ok, _ := path.Match(pattern, msg.Event); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Severities) > 0 {
+		for _, s := range r.Severities {
+			if s == msg.Severity {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// quiet reports whether msg falls in quiet hours and is not severe enough to be sent anyway.
+func (r *NotifierRoute) quiet(msg Notification) bool {
+	return inAnyWindow(r.QuietHours, msg.Time) && severityRank[msg.Severity] < severityRank[r.QuietSeverity]
+}
+
+// routeFor returns the routing for the device, taking per-device overrides into account.
+func (n *NotifierConfig) routeFor(device string) *NotifierRoute {
+	if r, ok := n.Devices[device]; ok {
+		return r
+	}
+	return &n.route
+}
+
+// render applies the template for the event, if there is one.
+func (n *NotifierConfig) render(msg Notification) (Notification, error) {
+	t, ok := n.templates[msg.Event]
+	if !ok {
+		for _, pattern := range n.patterns {
+			if matched, _ := path.Match(pattern, msg.Event); matched {
+				t = n.templates[pattern]
+				break
+			}
+		}
+	}
+	if t == nil {
+		return msg, nil
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, msg); err != nil {
+		return msg, err
+	}
+	msg.Message = buf.String()
+	return msg, nil
+}
+
+func (n *NotifierConfig) allow() bool {
+	if n.RateLimit <= 0 {
+		return true
+	}
+	n.rateMutex.Lock()
+	defer n.rateMutex.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(n.RatePeriod))
+	for len(n.sent) > 0 && n.sent[0].Before(cutoff) {
+		n.sent = n.sent[1:]
+	}
+	if len(n.sent) >= n.RateLimit {
+		return false
+	}
+	n.sent = append(n.sent, time.Now())
+	return true
+}
+
+// notify sends n to every notifier that wants it, in the background.
+func notify(n Notification) {
+	// the leader sends them
+	if !isLeader() || maintenanceSuppresses(n) {
+		return
+	}
+	notifiers := []*NotifierConfig{}
+	cfg := currentConfig()
+	for i := range cfg.Notifiers {
+		notifiers = append(notifiers, &cfg.Notifiers[i])
+	}
+	// and the tenant owning the device
+	for i := range cfg.Tenants {
+		if t := &cfg.Tenants[i]; t.Name == cfg.tenants[n.Device] {
+			for j := range t.Notifiers {
+				notifiers = append(notifiers, &t.Notifiers[j])
+			}
+		}
+	}
+	for _, c := range notifiers {
+		c := c
+		route := c.routeFor(n.Device)
+		if !route.wants(n) {
+			continue
+		}
+		if route.quiet(n) {
+			notificationsSent.WithLabelValues(c.Name, "suppressed").Inc()
+			continue
+		}
+		if !c.allow() {
+			notificationsSent.WithLabelValues(c.Name, "rate_limited").Inc()
+			continue
+		}
+		go func() {
+			n, err := c.render(n)
+			if err == nil {
+				err = c.notifier.notify(n)
+			}
+			if err != nil {
+				slog.Error("Error sending notification", "device", n.Device, "event", n.Event, "notifier", c.Name, "err", err)
+				notificationsSent.WithLabelValues(c.Name, "failure").Inc()
+				return
+			}
+			notificationsSent.WithLabelValues(c.Name, "success").Inc()
+		}()
+	}
+}
+
+// displayName is the device's displayName from the config file, or the name
+// it has in the firmware, falling back to the exporter's name for it; it runs
+// under mutex.
+func displayName(device string) string {
+	if name := currentConfig().Targets[device].DisplayName; name != "" {
+		return name
+	}
+	if name := deviceNames[device]; name != "" {
+		return name
+	}
+	return device
+}
+
+func eventMessage(e Event) string {
+	to := strings.ToLower(e.To)
+	switch e.Type {
+	case "door":
+		switch to {
+		case "open":
+			return "Door is open"
+		case "closed":
+			return "Door closed"
+		}
+		return "Door is " + to
+	case "light":
+		return "Light turned " + to
+	case "motion":
+		if to == "on" {
+			return "Motion detected"
+		}
+		return "Motion cleared"
+	case "obstruction":
+		if to == "on" {
+			return "Door is obstructed"
+		}
+		return "Obstruction cleared"
+	case "connectivity":
+		if to == "offline" {
+			return "Device is unreachable"
+		}
+		return "Device is reachable again"
+	}
+	return fmt.Sprintf("%s changed from %s to %s", e.Type, e.From, e.To)
+}
+
+func eventSeverity(event string) string {
+	switch event {
+	case "connectivity.offline":
+		return severityCritical
+	case "obstruction.on":
+		return severityWarning
+	}
+	return severityInfo
+}
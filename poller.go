@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	pollerMinBackoff = time.Second
+	pollerMaxBackoff = 5 * time.Minute
+)
+
+var pollerRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "homekit_ratgdo_poller_restarts_total",
+	Help: "Times the poll loop of a device was restarted after a panic.",
+}, []string{"device"})
+
+func init() {
+	prometheus.MustRegister(pollerRestarts)
+}
+
+// poll fetches every target each -poll-interval, or its own interval. The
+// targets are spread over the interval from a random start, and every poll is
+// shifted by up to -poll-jitter, so devices on the same access point, or
+// polled by several exporters, aren't all asked at once. Targets added later
+// start at a random point of the interval.
+func poll() {
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	pollers = map[string]context.CancelFunc{}
+	start := rand.Float64()
+	for i, t := range targets {
+		interval := t.pollInterval()
+		if interval <= 0 {
+			continue
+		}
+		offset := (start + float64(i)/float64(len(targets))) * float64(interval)
+		startPoller(t, time.Duration(offset)%interval)
+	}
+}
+
+// startPoller starts the poll loop of a target; it runs with targetsMutex held.
+func startPoller(t Target, offset time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pollers[t.Name] = cancel
+	go supervisePoller(ctx, t, offset)
+}
+
+// sleep waits for d, and returns false instead if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// supervisePoller runs the poll loop of a target until ctx is done, and
+// restarts it when it panics, waiting longer each time it panics again soon
+// after, so one device can't stop the others from being polled.
+func supervisePoller(ctx context.Context, t Target, offset time.Duration) {
+	if !sleep(ctx, offset) {
+		return
+	}
+	backoff := pollerMinBackoff
+	for {
+		started := time.Now()
+		r := runPoller(ctx, t)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > pollerMaxBackoff {
+			backoff = pollerMinBackoff
+		}
+		slog.Error("Poller crashed, restarting", "device", t.Name, "panic", r, "in", backoff)
+		pollerRestarts.WithLabelValues(t.Name).Inc()
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff = min(2*backoff, pollerMaxBackoff)
+	}
+}
+
+// runPoller polls t until ctx is done or it panics, and returns what it panicked with.
+func runPoller(ctx context.Context, t Target) (panicked interface{}) {
+	defer func() {
+		if panicked = recover(); panicked != nil {
+			slog.Debug("Poller panic", "device", t.Name, "stack", string(debug.Stack()))
+			capturePanic(panicked)
+		}
+	}()
+	for {
+		if isLeader() && maintenanceMode(t.Name) != maintenancePause {
+			ctx, span := tracer.Start(ctx, "poll")
+			fetchShared(ctx, t)
+			span.End()
+		}
+
+		interval := t.pollInterval()
+		jitter := (rand.Float64()*2 - 1) * pollJitter * float64(interval)
+		if !sleep(ctx, interval+time.Duration(jitter)) {
+			return nil
+		}
+	}
+}
+
+// lastFetchError returns the error of a device whose last fetch failed.
+func lastFetchError() error {
+	for _, d := range allDevices() {
+		d.mu.Lock()
+		lastError := d.LastError
+		d.mu.Unlock()
+		if lastError != "" {
+			return fmt.Errorf("%s: %s", d.Name, lastError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+var pprofAddress string
+
+func init() {
+	flag.StringVar(&pprofAddress, "web.pprof-address", "", "Address of a separate admin listener serving the Go profiler at /debug/pprof/, like localhost:6060 (disabled when empty)")
+}
+
+// startPprof serves the profiler on its own listener, so it isn't reachable
+// wherever the metrics are.
+func startPprof() error {
+	l, err := net.Listen("tcp", pprofAddress)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	slog.Info("Serving pprof", "address", pprofAddress)
+	go func() {
+		slog.Error("Error serving pprof", "err", http.Serve(l, mux))
+	}()
+	return nil
+}
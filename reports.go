@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReportsConfig schedules summary reports to the notifiers.
+type ReportsConfig struct {
+	// Daily is the local time of the daily report, like "21:00".
+	Daily string `json:"daily"`
+	// Weekly is the weekday and local time of the weekly report, like "sunday 21:00".
+	Weekly string `json:"weekly"`
+
+	daily         time.Duration
+	weekly        time.Duration
+	weeklyWeekday time.Weekday
+}
+
+// report summarizes what a device did in a period.
+type report struct {
+	Device              string    `json:"device"`
+	Since               time.Time `json:"since"`
+	Until               time.Time `json:"until"`
+	DoorCycles          int       `json:"doorCycles"`
+	OpenSeconds         float64   `json:"openSeconds"`
+	LongestOpenSeconds  float64   `json:"longestOpenSeconds"`
+	MotionEvents        int       `json:"motionEvents"`
+	Obstructions        int       `json:"obstructions"`
+	Outages             int       `json:"outages"`
+	OfflineSeconds      float64   `json:"offlineSeconds"`
+	DeviceUptimeSeconds float64   `json:"deviceUptimeSeconds"`
+	CrashCount          int       `json:"crashCount"`
+}
+
+var (
+	reportPeriods = map[string]time.Duration{
+		"daily":  24 * time.Hour,
+		"weekly": 7 * 24 * time.Hour,
+	}
+
+	// since when events were recorded, back to the first stored one
+	observedSince = time.Now()
+)
+
+func (c *ReportsConfig) validate() error {
+	var err error
+	if c.Daily != "" {
+		if c.daily, err = parseClock(c.Daily); err != nil {
+			return err
+		}
+	}
+	if c.Weekly != "" {
+		fields := strings.Fields(c.Weekly)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid weekly %q, use like \"sunday 21:00\"", c.Weekly)
+		}
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(fields[0], d.String()) {
+				c.weeklyWeekday, found = d, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid weekday %q", fields[0])
+		}
+		if c.weekly, err = parseClock(fields[1]); err != nil {
+			return err
+		}
+	}
+	if c.Daily == "" && c.Weekly == "" {
+		return errors.New("daily or weekly is required")
+	}
+	return nil
+}
+
+// intervals adds up how long, and longest, a state lasted from the transitions
+// of one type, oldest first. in tells whether a state counts.
+func intervals(events []Event, since, until time.Time, in func(state string) bool) (total, longest time.Duration) {
+	var start time.Time
+	if len(events) > 0 && in(events[0].From) {
+		start = since
+	}
+	add := func(end time.Time) {
+		d := end.Sub(start)
+		total += d
+		if d > longest {
+			longest = d
+		}
+	}
+	for _, e := range events {
+		switch {
+		case start.IsZero() && in(e.To):
+			start = e.Time
+		case !start.IsZero() && !in(e.To):
+			add(e.Time)
+			start = time.Time{}
+		}
+	}
+	if !start.IsZero() {
+		add(until)
+	}
+	return total, longest
+}
+
+// buildReports summarizes the events of every device since then.
+func buildReports(since time.Time) ([]report, error) {
+	until := time.Now()
+	events, err := recentEvents(eventQuery{since: since, until: until, limit: -1})
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is known about the time before the exporter was watching
+	if since.Before(observedSince) {
+		since = observedSince
+	}
+
+	var reports []report
+	for name, d := range allDevices() {
+		d.mu.Lock()
+		r := report{Device: name, Since: since, Until: until, CrashCount: d.Status.CrashCount}
+		if d.Seen {
+			r.DeviceUptimeSeconds = float64(d.Status.UpTime) / 1000
+		}
+		byType := map[string][]Event{}
+		// events are newest first
+		for i := len(events) - 1; i >= 0; i-- {
+			e := events[i]
+			if e.Device != name {
+				continue
+			}
+			byType[e.Type] = append(byType[e.Type], e)
+			switch {
+			case e.Type == "door" && e.To == "Closed":
+				r.DoorCycles++
+			case e.Type == "motion" && e.To == "on":
+				r.MotionEvents++
+			case e.Type == "obstruction" && e.To == "on":
+				r.Obstructions++
+			case e.Type == "connectivity" && e.To == "offline":
+				r.Outages++
+			}
+		}
+
+		doorSince, doors := since, byType["door"]
+		if len(doors) == 0 && d.Seen && d.Status.GarageDoorState != "Closed" {
+			// open for the whole period, or since it was first seen open
+			doorSince = maxTime(since, d.OpenSince)
+			doors = []Event{{From: d.Status.GarageDoorState, To: d.Status.GarageDoorState}}
+		}
+		open, longest := intervals(doors, doorSince, until, func(s string) bool { return s != "Closed" })
+		r.OpenSeconds, r.LongestOpenSeconds = open.Seconds(), longest.Seconds()
+		offline, _ := intervals(byType["connectivity"], since, until, func(s string) bool { return s == "offline" })
+		r.OfflineSeconds = offline.Seconds()
+		d.mu.Unlock()
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Device < reports[j].Device })
+	return reports, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (r report) message() string {
+	return fmt.Sprintf("%d door cycles, open for %s (longest %s), %d motion events, %d obstructions, %d outages (%s offline), device up for %s with %d crashes",
+		r.DoorCycles, humanDuration(time.Duration(r.OpenSeconds)*time.Second), humanDuration(time.Duration(r.LongestOpenSeconds)*time.Second),
+		r.MotionEvents, r.Obstructions, r.Outages, humanDuration(time.Duration(r.OfflineSeconds)*time.Second),
+		humanDuration(time.Duration(r.DeviceUptimeSeconds)*time.Second), r.CrashCount)
+}
+
+func sendReports(period string) {
+	reports, err := buildReports(time.Now().Add(-reportPeriods[period]))
+	if err != nil {
+		slog.Error("Error building report", "period", period, "err", err)
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, r := range reports {
+		notify(Notification{
+			Event:    "report." + period,
+			Device:   r.Device,
+			Title:    displayName(r.Device),
+			Message:  strings.ToUpper(period[:1]) + period[1:] + " report: " + r.message(),
+			Severity: severityInfo,
+			Time:     r.Until,
+		})
+	}
+}
+
+// scheduleReports sends the configured reports when they are due.
+func scheduleReports() {
+	defer reportPanic()
+	for last := time.Now(); ; {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		// the config may have been reloaded
+		c := currentConfig().Reports
+		if c == nil {
+			last = now
+			continue
+		}
+		due := func(clock time.Duration) bool {
+			at := startOfDay(now).Add(clock)
+			return !last.After(at) && !now.Before(at)
+		}
+		if c.Daily != "" && due(c.daily) {
+			sendReports("daily")
+		}
+		if c.Weekly != "" && now.Weekday() == c.weeklyWeekday && due(c.weekly) {
+			sendReports("weekly")
+		}
+		last = now
+	}
+}
+
+// reportsHandler serves /api/v1/reports?period=daily|weekly, or ?since=, for the period up to now.
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	since := time.Now().Add(-reportPeriods["daily"])
+	if p := r.URL.Query().Get("period"); p != "" {
+		d, ok := reportPeriods[p]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "period must be daily or weekly")
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = parseTime(s); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+	}
+	reports, err := buildReports(since)
+	if err != nil {
+		slog.Error("Error building report", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "error building report")
+		return
+	}
+	if visible := visibleDevices(r); visible != nil {
+		var own []report
+		for _, rep := range reports {
+			if visible[rep.Device] {
+				own = append(own, rep)
+			}
+		}
+		reports = own
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
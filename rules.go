@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rulesJob             string
+	rulesDoorOpenFor     time.Duration
+	rulesDeviceDownFor   time.Duration
+	rulesHeapMinBytes    int
+	rulesCrashesPerHour  int
+	rulesObstructedFor   time.Duration
+	rulesHeapPredictSpan time.Duration
+)
+
+func init() {
+	flag.StringVar(&rulesJob, "rules.job", "homekit-ratgdo", "Prometheus job name of the exporter, used by the generated rules")
+	flag.DurationVar(&rulesDoorOpenFor, "rules.door-open-for", 15*time.Minute, "Alert when a door has been open this long")
+	flag.DurationVar(&rulesDeviceDownFor, "rules.device-down-for", 5*time.Minute, "Alert when a device has been unreachable this long")
+	flag.IntVar(&rulesHeapMinBytes, "rules.heap-min-bytes", 4096, "Alert when free heap is predicted to drop below this many bytes")
+	flag.DurationVar(&rulesHeapPredictSpan, "rules.heap-predict", 24*time.Hour, "How far ahead the free heap trend is predicted")
+	flag.IntVar(&rulesCrashesPerHour, "rules.crashes-per-hour", 2, "Alert when a device crashes more often than this per hour")
+	flag.DurationVar(&rulesObstructedFor, "rules.obstructed-for", time.Minute, "Alert when a door that isn't closed has been obstructed this long")
+}
+
+var rulesTemplate = template.Must(template.New("rules").Parse(`groups:
+  - name: homekit-ratgdo.rules
+    rules:
+      - record: homekit_ratgdo:doors_open:count
+        expr: count by (location) ({{.DoorState}} == 1)
+      - record: homekit_ratgdo:free_heap_bytes:predict_linear
+        expr: predict_linear({{.FreeHeap}}[6h], {{.HeapPredictSeconds}})
+      - record: homekit_ratgdo:crashes:increase1h
+        expr: increase({{.CrashCount}}[1h])
+
+  - name: homekit-ratgdo.alerts
+    rules:
+      - alert: GarageDoorLeftOpen
+        expr: {{.DoorOpenSeconds}} > {{.DoorOpenSecondsThreshold}}
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{"{{"}} $labels.deviceName {{"}}"}} has been open for {{.DoorOpenFor}}"
+          description: "The garage door at {{"{{"}} $labels.location {{"}}"}} has been open for {{"{{"}} $value | humanizeDuration {{"}}"}}."
+
+      - alert: RatgdoDeviceDown
+        expr: {{.Up}} == 0 unless on (device) {{.Maintenance}} > 0
+        for: {{.DeviceDownFor}}
+        labels:
+          severity: critical
+        annotations:
+          summary: "ratgdo exporter {{"{{"}} $labels.instance {{"}}"}} can't reach {{"{{"}} $labels.device {{"}}"}}"
+          description: "Fetching status.json has been failing for more than {{.DeviceDownFor}}."
+
+      - alert: RatgdoExporterDown
+        expr: up{job="{{.Job}}"} == 0
+        for: {{.DeviceDownFor}}
+        labels:
+          severity: critical
+        annotations:
+          summary: "ratgdo exporter {{"{{"}} $labels.instance {{"}}"}} is down"
+          description: "Prometheus hasn't been able to scrape the exporter for more than {{.DeviceDownFor}}."
+
+      - alert: RatgdoHeapExhaustion
+        expr: homekit_ratgdo:free_heap_bytes:predict_linear < {{.HeapMinBytes}}
+        for: 30m
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{"{{"}} $labels.deviceName {{"}}"}} is running out of heap"
+          description: "Free heap is predicted to drop below {{.HeapMinBytes}} bytes within {{.HeapPredict}}, the device will probably crash."
+
+      - alert: RatgdoCrashing
+        expr: homekit_ratgdo:crashes:increase1h > {{.CrashesPerHour}}
+        labels:
+          severity: warning
+        annotations:
+          summary: "{{"{{"}} $labels.deviceName {{"}}"}} is crashing"
+          description: "The device crashed {{"{{"}} $value {{"}}"}} times in the last hour."
+
+      - alert: GarageDoorObstructed
+        expr: {{.Obstructed}} == 1 and on (location, accessoryID) {{.DoorState}} != 0
+        for: {{.ObstructedFor}}
+        labels:
+          severity: critical
+        annotations:
+          summary: "{{"{{"}} $labels.deviceName {{"}}"}} is obstructed"
+          description: "The safety beam is blocked while the door isn't closed, so the door can't be closed remotely."
+
+      - alert: GarageDoorCloseReversed
+        expr: increase({{.CloseReversals}}[10m]) > 0
+        labels:
+          severity: critical
+        annotations:
+          summary: "{{"{{"}} $labels.deviceName {{"}}"}} reversed while closing"
+          description: "The door was obstructed while closing and didn't close, something may be in its way."
+`))
+
+// promDuration formats a duration the way Prometheus rule files expect, e.g. 15m or 1h30m.
+func promDuration(d time.Duration) string {
+	s := d.String()
+	s = strings.Replace(s, "m0s", "m", 1)
+	s = strings.Replace(s, "h0m", "h", 1)
+	return s
+}
+
+func runRules() {
+	name := func(c prometheus.Collector) string { return metricName(c) }
+	err := rulesTemplate.Execute(os.Stdout, map[string]interface{}{
+		"Job":                      rulesJob,
+		"Up":                       name(deviceUp),
+		"DoorState":                name(garageDoorState),
+		"DoorOpenSeconds":          name(doorOpenSeconds),
+		"FreeHeap":                 name(freeHeap),
+		"CrashCount":               name(crashCount),
+		"Obstructed":               name(garageObstructed),
+		"CloseReversals":           name(closeReversals),
+		"Maintenance":              name(maintenanceGauge),
+		"DoorOpenFor":              promDuration(rulesDoorOpenFor),
+		"DoorOpenSecondsThreshold": int(rulesDoorOpenFor.Seconds()),
+		"DeviceDownFor":            promDuration(rulesDeviceDownFor),
+		"HeapMinBytes":             rulesHeapMinBytes,
+		"HeapPredict":              promDuration(rulesHeapPredictSpan),
+		"HeapPredictSeconds":       int(rulesHeapPredictSpan.Seconds()),
+		"CrashesPerHour":           rulesCrashesPerHour,
+		"ObstructedFor":            promDuration(rulesObstructedFor),
+	})
+	if err != nil {
+		fatal("Error writing rules", "err", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrapeRateLimit  int
+	scrapeRatePeriod time.Duration
+
+	scrapeLimitMutex sync.Mutex
+	// recent scrapes by client, oldest first; guarded by scrapeLimitMutex
+	clientScrapes = map[string][]time.Time{}
+
+	rateLimitedScrapes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_rate_limited_scrapes_total",
+		Help: "Scrapes refused because the client went over -web.scrape-rate-limit.",
+	})
+)
+
+func init() {
+	flag.IntVar(&scrapeRateLimit, "web.scrape-rate-limit", 0, "Answer /metrics at most this many times per -web.scrape-rate-period for each client address, refusing the rest with 429 (0 is unlimited)")
+	flag.DurationVar(&scrapeRatePeriod, "web.scrape-rate-period", time.Minute, "Period of -web.scrape-rate-limit")
+
+	prometheus.MustRegister(rateLimitedScrapes)
+}
+
+// clientAddress is the IP address of the client of r, without the port.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowScrape records a scrape by client and returns how long it has to wait
+// if it is over the rate limit.
+func allowScrape(client string) (time.Duration, bool) {
+	scrapeLimitMutex.Lock()
+	defer scrapeLimitMutex.Unlock()
+	now := time.Now()
+	for c, times := range clientScrapes {
+		for len(times) > 0 && now.Sub(times[0]) >= scrapeRatePeriod {
+			times = times[1:]
+		}
+		if len(times) == 0 {
+			delete(clientScrapes, c)
+		} else {
+			clientScrapes[c] = times
+		}
+	}
+	times := clientScrapes[client]
+	if len(times) >= scrapeRateLimit {
+		return times[0].Add(scrapeRatePeriod).Sub(now), false
+	}
+	clientScrapes[client] = append(times, now)
+	return 0, true
+}
+
+// rateLimitScrapes refuses requests to h from clients that went over
+// -web.scrape-rate-limit, so a scraper with a too short interval doesn't
+// make the exporter fetch the devices that often.
+func rateLimitScrapes(h http.HandlerFunc) http.HandlerFunc {
+	if scrapeRateLimit <= 0 {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait, ok := allowScrape(clientAddress(r)); !ok {
+			rateLimitedScrapes.Inc()
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many scrapes", http.StatusTooManyRequests)
+			return
+		}
+		h(w, r)
+	}
+}
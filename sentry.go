@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+)
+
+var (
+	sentryDSN         string
+	sentryEnvironment string
+	sentryFailures    int
+	sentryEnabled     bool
+)
+
+func init() {
+	flag.StringVar(&sentryDSN, "sentry.dsn", "", "Sentry (or compatible, like GlitchTip) DSN to report panics and failing devices to (also read from $SENTRY_DSN)")
+	flag.StringVar(&sentryEnvironment, "sentry.environment", "", "Environment to tag Sentry events with, like the house they come from")
+	flag.IntVar(&sentryFailures, "sentry.failures", 5, "Report a device to Sentry once this many fetches in a row failed")
+
+	onUpdate(func(d *deviceState) {
+		// once per streak of failures
+		if !sentryEnabled || d.Failures != sentryFailures {
+			return
+		}
+		sentry.WithScope(func(scope *sentry.Scope) {
+			scope.SetLevel(sentry.LevelError)
+			scope.SetTag("device", d.Name)
+			scope.SetFingerprint([]string{"fetch-failures", d.Name})
+			device := sentry.Context{
+				"name":        d.Name,
+				"failures":    d.Failures,
+				"last_error":  d.LastError,
+				"online":      d.Online,
+				"last_update": d.LastUpdate,
+			}
+			if t, ok := findTarget(d.Name); ok {
+				device["url"] = t.URL
+			}
+			if d.Seen {
+				device["firmware"] = d.Status.FirmwareVersion
+				device["device_name"] = d.Status.DeviceName
+				device["free_heap"] = d.Status.FreeHeap
+				device["crash_count"] = d.Status.CrashCount
+			}
+			scope.SetContext("device", device)
+			sentry.CaptureMessage(fmt.Sprintf("%s: %d fetches failed in a row: %s", d.Name, d.Failures, d.LastError))
+		})
+	})
+}
+
+func startSentry() error {
+	if sentryDSN == "" && os.Getenv("SENTRY_DSN") == "" {
+		return nil
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         sentryDSN,
+		Environment: sentryEnvironment,
+	})
+	if err != nil {
+		return err
+	}
+	sentryEnabled = true
+	slog.Info("Reporting errors to Sentry")
+	return nil
+}
+
+// reportPanic sends a panic to Sentry before letting it crash the exporter.
+// Defer it at the top of long running goroutines.
+func reportPanic() {
+	if !sentryEnabled {
+		return
+	}
+	if r := recover(); r != nil {
+		capturePanic(r)
+		panic(r)
+	}
+}
+
+// capturePanic sends a recovered panic to Sentry.
+func capturePanic(r interface{}) {
+	if !sentryEnabled {
+		return
+	}
+	sentry.CurrentHub().Recover(r)
+	sentry.Flush(5 * time.Second)
+}
+
+// reportPanics sends panics in handlers to Sentry; net/http then logs them as before.
+func reportPanics(h http.Handler) http.Handler {
+	if !sentryEnabled {
+		return h
+	}
+	return sentryhttp.New(sentryhttp.Options{Repanic: true}).Handle(h)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// emailNotifier sends notifications over SMTP.
+type emailNotifier struct {
+	c       *NotifierConfig
+	subject *template.Template
+}
+
+func newEmailNotifier(c *NotifierConfig) (*emailNotifier, error) {
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return nil, errors.New("email needs host, from and to")
+	}
+	if c.Port == 0 {
+		c.Port = 587
+		if c.TLS == "tls" {
+			c.Port = 465
+		}
+	}
+	switch c.TLS {
+	case "":
+		c.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("unknown tls mode %q, use starttls, tls or none", c.TLS)
+	}
+	subject := c.Subject
+	if subject == "" {
+		subject = "{{.Title}}: {{.Message}}"
+	}
+	t, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, err
+	}
+	return &emailNotifier{c: c, subject: t}, nil
+}
+
+func (n *emailNotifier) notify(msg Notification) error {
+	var subject bytes.Buffer
+	if err := n.subject.Execute(&subject, msg); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.c.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(n.c.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&body, "Date: %s\r\n", msg.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n\r\nDevice: %s (%s)\r\nEvent: %s\r\nTime: %s\r\n",
+		msg.Message, msg.Title, msg.Device, msg.Event, msg.Time.Format(time.RFC1123))
+
+	return n.send(body.Bytes())
+}
+
+func (n *emailNotifier) send(message []byte) error {
+	addr := net.JoinHostPort(n.c.Host, strconv.Itoa(n.c.Port))
+	tlsConfig := &tls.Config{ServerName: n.c.Host}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if n.c.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	c, err := smtp.NewClient(conn, n.c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if n.c.TLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.c.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.c.Username, n.c.Password, n.c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.c.From); err != nil {
+		return err
+	}
+	for _, to := range n.c.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal read-only SNMP v1/v2c agent exposing the RATGDO-EXPORTER-MIB (see RATGDO-EXPORTER-MIB.txt).
+
+var (
+	snmpListenAddress string
+	snmpCommunity     string
+	snmpBaseOID       string
+)
+
+func init() {
+	flag.StringVar(&snmpListenAddress, "snmp.listen-address", "", "UDP address for the SNMP agent, e.g. :161 (disabled when empty)")
+	flag.StringVar(&snmpCommunity, "snmp.community", "public", "SNMP read community")
+	flag.StringVar(&snmpBaseOID, "snmp.base-oid", "1.3.6.1.4.1.8072.9999.9999.1", "OID the RATGDO-EXPORTER-MIB is rooted at")
+}
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOID         = 0x06
+	berSequence    = 0x30
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduGetBulk  = 0xa5
+
+	noSuchObject = 0x80
+	endOfMibView = 0x82
+)
+
+type oid []int
+
+func parseOID(s string) (oid, error) {
+	var o oid
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		o = append(o, n)
+	}
+	return o, nil
+}
+
+func (o oid) less(other oid) bool {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] != other[i] {
+			return o[i] < other[i]
+		}
+	}
+	return len(o) < len(other)
+}
+
+func (o oid) equal(other oid) bool {
+	return !o.less(other) && !other.less(o)
+}
+
+func (o oid) child(n ...int) oid {
+	return append(append(oid{}, o...), n...)
+}
+
+// snmpValue is an already encoded BER value.
+type snmpValue []byte
+
+type snmpVar struct {
+	oid   oid
+	value snmpValue
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	return append(append([]byte{tag}, berLength(len(content))...), content...)
+}
+
+func berInt(tag byte, n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berTLV(tag, b)
+}
+
+// berUint encodes the unsigned application types (Gauge32, TimeTicks).
+func berUint(tag byte, n uint32) []byte {
+	b := berInt(berInteger, int64(n))
+	b[0] = tag
+	return b
+}
+
+func berOIDValue(o oid) []byte {
+	if len(o) < 2 {
+		return berTLV(berOID, nil)
+	}
+	b := []byte{byte(o[0]*40 + o[1])}
+	for _, n := range o[2:] {
+		var enc []byte
+		enc = append(enc, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return berTLV(berOID, b)
+}
+
+type berReader struct {
+	b []byte
+}
+
+func (r *berReader) next() (byte, []byte, error) {
+	if len(r.b) < 2 {
+		return 0, nil, errors.New("truncated")
+	}
+	tag := r.b[0]
+	length := int(r.b[1])
+	off := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n > 3 || len(r.b) < 2+n {
+			return 0, nil, errors.New("bad length")
+		}
+		length = 0
+		for _, c := range r.b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		off += n
+	}
+	if len(r.b) < off+length {
+		return 0, nil, errors.New("truncated")
+	}
+	content := r.b[off : off+length]
+	r.b = r.b[off+length:]
+	return tag, content, nil
+}
+
+func (r *berReader) int() (int64, error) {
+	tag, content, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	if tag != berInteger || len(content) == 0 || len(content) > 8 {
+		return 0, errors.New("expected integer")
+	}
+	n := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(content []byte) oid {
+	if len(content) == 0 {
+		return nil
+	}
+	o := oid{int(content[0]) / 40, int(content[0]) % 40}
+	n := 0
+	for _, c := range content[1:] {
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			o = append(o, n)
+			n = 0
+		}
+	}
+	return o
+}
+
+func truthValue(b bool) snmpValue {
+	if b {
+		return berInt(berInteger, 1)
+	}
+	return berInt(berInteger, 2)
+}
+
+func doorStateValue(state string) int64 {
+	switch state {
+	case "Closed":
+		return 1
+	case "Open":
+		return 2
+	case "Opening":
+		return 3
+	case "Closing":
+		return 4
+	case "Stopped":
+		return 5
+	}
+	return 6
+}
+
+// snmpVars snapshots the MIB in OID order.
+func snmpVars(base oid) []snmpVar {
+	locked, unlock := lockDevices()
+	defer unlock()
+
+	vars := []snmpVar{{base.child(1, 0), berInt(berInteger, int64(len(locked)))}}
+	entry := base.child(2, 1)
+	columns := []func(d *deviceState, i int) snmpValue{
+		func(d *deviceState, i int) snmpValue { return berInt(berInteger, int64(i)) },
+		func(d *deviceState, i int) snmpValue { return berTLV(berOctetString, []byte(d.Name)) },
+		func(d *deviceState, i int) snmpValue { return truthValue(d.Online) },
+		func(d *deviceState, i int) snmpValue {
+			return berInt(berInteger, doorStateValue(d.Status.GarageDoorState))
+		},
+		// upTime is reported by the firmware in milliseconds
+		func(d *deviceState, i int) snmpValue { return berUint(berTimeTicks, uint32(d.Status.UpTime/10)) },
+		func(d *deviceState, i int) snmpValue { return truthValue(d.Status.GarageLightOn) },
+		func(d *deviceState, i int) snmpValue { return truthValue(d.Status.GarageMotion) },
+		func(d *deviceState, i int) snmpValue { return truthValue(d.Status.GarageObstructed) },
+		func(d *deviceState, i int) snmpValue {
+			if d.LastUpdate.IsZero() {
+				return berUint(berGauge32, 0)
+			}
+			return berUint(berGauge32, uint32(time.Since(d.LastUpdate).Seconds()))
+		},
+	}
+	for c, column := range columns {
+		for i, d := range locked {
+			vars = append(vars, snmpVar{entry.child(c+1, i+1), column(d, i+1)})
+		}
+	}
+	return vars
+}
+
+func handleSNMP(packet []byte, base oid) ([]byte, error) {
+	msg := berReader{packet}
+	tag, content, err := msg.next()
+	if err != nil || tag != berSequence {
+		return nil, errors.New("not an SNMP message")
+	}
+	r := berReader{content}
+	version, err := r.int()
+	if err != nil || version > 1 {
+		return nil, errors.New("unsupported SNMP version")
+	}
+	tag, community, err := r.next()
+	if err != nil || tag != berOctetString {
+		return nil, errors.New("missing community")
+	}
+	if string(community) != snmpCommunity {
+		return nil, errors.New("wrong community")
+	}
+	pduType, pdu, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	p := berReader{pdu}
+	requestID, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+	// for GetBulk these are non-repeaters and max-repetitions
+	nonRepeaters, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+	maxRepetitions, err := p.int()
+	if err != nil {
+		return nil, err
+	}
+	tag, bindings, err := p.next()
+	if err != nil || tag != berSequence {
+		return nil, errors.New("missing varbinds")
+	}
+	var requested []oid
+	for vb := (berReader{bindings}); len(vb.b) > 0; {
+		_, binding, err := vb.next()
+		if err != nil {
+			return nil, err
+		}
+		b := berReader{binding}
+		tag, o, err := b.next()
+		if err != nil || tag != berOID {
+			return nil, errors.New("bad varbind")
+		}
+		requested = append(requested, decodeOID(o))
+	}
+
+	vars := snmpVars(base)
+	lookup := func(o oid) snmpVar {
+		for _, v := range vars {
+			if v.oid.equal(o) {
+				return v
+			}
+		}
+		return snmpVar{o, snmpValue{noSuchObject, 0}}
+	}
+	after := func(o oid) snmpVar {
+		for _, v := range vars {
+			if o.less(v.oid) {
+				return v
+			}
+		}
+		return snmpVar{o, snmpValue{endOfMibView, 0}}
+	}
+
+	var results []snmpVar
+	switch pduType {
+	case pduGet:
+		for _, o := range requested {
+			results = append(results, lookup(o))
+		}
+	case pduGetNext:
+		for _, o := range requested {
+			results = append(results, after(o))
+		}
+	case pduGetBulk:
+		if version == 0 {
+			return nil, errors.New("GetBulk is not part of SNMPv1")
+		}
+		for i, o := range requested {
+			if int64(i) < nonRepeaters {
+				results = append(results, after(o))
+				continue
+			}
+			for n := int64(0); n < maxRepetitions && n < 100; n++ {
+				v := after(o)
+				results = append(results, v)
+				if v.value[0] == endOfMibView {
+					break
+				}
+				o = v.oid
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PDU type %#x", pduType)
+	}
+
+	errorStatus, errorIndex := 0, 0
+	if version == 0 {
+		// SNMPv1 has no exception values, it reports noSuchName instead
+		for i, v := range results {
+			if v.value[0] == noSuchObject || v.value[0] == endOfMibView {
+				errorStatus, errorIndex = 2, i+1
+				results = nil
+				for _, o := range requested {
+					results = append(results, snmpVar{o, berTLV(berNull, nil)})
+				}
+				break
+			}
+		}
+	}
+
+	var encoded []byte
+	for _, v := range results {
+		encoded = append(encoded, berTLV(berSequence, append(berOIDValue(v.oid), v.value...))...)
+	}
+	response := berInt(berInteger, requestID)
+	response = append(response, berInt(berInteger, int64(errorStatus))...)
+	response = append(response, berInt(berInteger, int64(errorIndex))...)
+	response = append(response, berTLV(berSequence, encoded)...)
+
+	out := berInt(berInteger, version)
+	out = append(out, berTLV(berOctetString, community)...)
+	out = append(out, berTLV(pduResponse, response)...)
+	return berTLV(berSequence, out), nil
+}
+
+func startSNMP() error {
+	base, err := parseOID(snmpBaseOID)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", snmpListenAddress)
+	if err != nil {
+		return err
+	}
+	slog.Info("Starting SNMP agent", "address", snmpListenAddress)
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				slog.Error("Error reading SNMP request", "err", err)
+				continue
+			}
+			response, err := handleSNMP(buf[:n], base)
+			if err != nil {
+				slog.Warn("Ignoring SNMP request", "remote", addr, "err", err)
+				continue
+			}
+			if _, err := conn.WriteTo(response, addr); err != nil {
+				slog.Error("Error writing SNMP response", "remote", addr, "err", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestBERLength(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x81, 0x80}},
+		{255, []byte{0x81, 0xff}},
+		{256, []byte{0x82, 0x01, 0x00}},
+		{70000, []byte{0x83, 0x01, 0x11, 0x70}},
+	}
+	for _, tt := range tests {
+		if got := berLength(tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("berLength(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		got := berInt(berInteger, tt.n)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("berInt(%d) = % x, want % x", tt.n, got, tt.want)
+		}
+		r := berReader{got}
+		if n, err := r.int(); err != nil || n != tt.n {
+			t.Errorf("reading berInt(%d) = %d, %v", tt.n, n, err)
+		}
+	}
+}
+
+func TestBERUint(t *testing.T) {
+	tests := []struct {
+		tag  byte
+		n    uint32
+		want []byte
+	}{
+		{berGauge32, 0, []byte{0x42, 0x01, 0x00}},
+		{berGauge32, 200, []byte{0x42, 0x02, 0x00, 0xc8}},
+		{berTimeTicks, math.MaxUint32, []byte{0x43, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := berUint(tt.tag, tt.n); !bytes.Equal(got, tt.want) {
+			t.Errorf("berUint(%#x, %d) = % x, want % x", tt.tag, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want []byte
+	}{
+		{"1.3.6.1.2.1.1.3.0", []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x03, 0x00}},
+		{"1.3.6.1.4.1.8072", []byte{0x06, 0x07, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xbf, 0x08}},
+		{"1.3.6.1.4.1.2000000", []byte{0x06, 0x08, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xfa, 0x89, 0x00}},
+	}
+	for _, tt := range tests {
+		o, err := parseOID(tt.oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := berOIDValue(o)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("berOIDValue(%s) = % x, want % x", tt.oid, got, tt.want)
+		}
+		r := berReader{got}
+		tag, content, err := r.next()
+		if err != nil || tag != berOID || !decodeOID(content).equal(o) {
+			t.Errorf("decoding %s = %v, %v", tt.oid, decodeOID(content), err)
+		}
+	}
+}
+
+func TestBERReaderLongLength(t *testing.T) {
+	content := bytes.Repeat([]byte{0x41}, 300)
+	r := berReader{append(berTLV(berOctetString, content), 0x05, 0x00)}
+	tag, got, err := r.next()
+	if err != nil || tag != berOctetString || !bytes.Equal(got, content) {
+		t.Fatalf("got tag %#x, %d bytes, %v", tag, len(got), err)
+	}
+	if tag, _, err := r.next(); err != nil || tag != berNull {
+		t.Errorf("got tag %#x, %v after the long value, want null", tag, err)
+	}
+	if _, _, err := r.next(); err == nil {
+		t.Error("no error reading past the end")
+	}
+}
+
+func TestOIDLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1.3.6.1", "1.3.6.2", true},
+		{"1.3.6", "1.3.6.1", true},
+		{"1.3.6.1", "1.3.6", false},
+		{"1.3.10", "1.3.9", false},
+		{"1.3.6", "1.3.6", false},
+	}
+	for _, tt := range tests {
+		a, _ := parseOID(tt.a)
+		b, _ := parseOID(tt.b)
+		if got := a.less(b); got != tt.want {
+			t.Errorf("%s < %s = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
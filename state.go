@@ -0,0 +1,272 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Event is a state transition observed between two fetches of the same device.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Device string    `json:"device"`
+	Type   string    `json:"type"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+}
+
+// deviceState is what the exporter remembers about a device between fetches.
+// Its fields are guarded by its own mu, so fetching or reading one device
+// doesn't wait for the others. The event and update handlers run with the
+// device's mu and then mutex held, mutex guarding what they share between
+// devices, and may take targetsMutex to look up targets; always lock mu first
+// and never take a device's mu with mutex or targetsMutex held.
+type deviceState struct {
+	mu        sync.Mutex
+	Name      string
+	Status    Status
+	RawStatus []byte
+	// door state before the current one, to tell where a transition came from
+	PreviousDoorState string
+	Seen              bool
+	Online            bool
+	LastUpdate        time.Time
+	LastFetch         time.Time
+	FetchDuration     time.Duration
+	LastError         string
+	OpenSince         time.Time
+	// when the door left open or closed, zero while it is either
+	TransitionSince time.Time
+	// fetches that failed in a row
+	Failures int
+	// exporter-derived counters, restored from storage on start
+	Cycles           int
+	ObstructionCount int
+	Crashes          int
+	CloseReversals   int
+	LastCrashCount   int
+	crashesKnown     bool
+	// the labels of the homekit_ratgdo_info series of the device, and of
+	// its other series at the last fetch
+	infoLabels prometheus.Labels
+	labels     []string
+	// when the obstruction sensor triggered, for the last day
+	Obstructions []time.Time
+}
+
+var (
+	// the devices of the targets, by name; the map is replaced as a whole when
+	// the targets change, so it can be read without a lock, see allDevices
+	deviceMap atomic.Pointer[map[string]*deviceState]
+	// the names the devices have in the firmware; guarded by mutex
+	deviceNames    = map[string]string{}
+	eventHandlers  []func(Event)
+	updateHandlers []func(*deviceState)
+)
+
+func onEvent(f func(Event)) {
+	eventHandlers = append(eventHandlers, f)
+}
+
+func onUpdate(f func(*deviceState)) {
+	updateHandlers = append(updateHandlers, f)
+}
+
+// allDevices returns the devices of the current targets. The map must not be changed.
+func allDevices() map[string]*deviceState {
+	if m := deviceMap.Load(); m != nil {
+		return *m
+	}
+	return nil
+}
+
+// getDevice returns the device of a target. For a name that isn't, or isn't
+// anymore, one of the targets it's a new device nobody else sees.
+func getDevice(name string) *deviceState {
+	if d, ok := allDevices()[name]; ok {
+		return d
+	}
+	return &deviceState{Name: name}
+}
+
+// lockDevices locks every device, in the same order each time, and returns
+// them sorted by name and a function unlocking them again.
+func lockDevices() ([]*deviceState, func()) {
+	var locked []*deviceState
+	for _, d := range allDevices() {
+		locked = append(locked, d)
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i].Name < locked[j].Name })
+	for _, d := range locked {
+		d.mu.Lock()
+	}
+	return locked, func() {
+		for _, d := range locked {
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *deviceState) openDuration() time.Duration {
+	if d.OpenSince.IsZero() {
+		return 0
+	}
+	return time.Since(d.OpenSince)
+}
+
+// obstructionsSince counts how often the obstruction sensor triggered since t.
+func (d *deviceState) obstructionsSince(t time.Time) int {
+	n := 0
+	for _, o := range d.Obstructions {
+		if !o.Before(t) {
+			n++
+		}
+	}
+	return n
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// recordStatus and recordFailure update a device with the result of a fetch;
+// they run with its mu held.
+func recordStatus(d *deviceState, start time.Time, status Status) {
+	name := d.Name
+	now := time.Now()
+	d.LastFetch = start
+	d.FetchDuration = now.Sub(start)
+	d.LastError = ""
+
+	labels := deviceLabels(name, status)
+	if !d.Seen {
+		// start the counters at what they were before a restart
+		doorCycles.WithLabelValues(labels...).Add(float64(d.Cycles))
+		obstructionsTotal.WithLabelValues(labels...).Add(float64(d.ObstructionCount))
+		crashesTotal.WithLabelValues(labels...).Add(float64(d.Crashes))
+		closeReversals.WithLabelValues(labels...).Add(float64(d.CloseReversals))
+	}
+	countersChanged := false
+	// the firmware's count starts over when its crash log is cleared
+	if d.crashesKnown && status.CrashCount > d.LastCrashCount {
+		d.Crashes += status.CrashCount - d.LastCrashCount
+		crashesTotal.WithLabelValues(labels...).Add(float64(status.CrashCount - d.LastCrashCount))
+	}
+	if !d.crashesKnown || status.CrashCount != d.LastCrashCount {
+		d.LastCrashCount = status.CrashCount
+		d.crashesKnown = true
+		countersChanged = true
+	}
+
+	// the door was in its last state until now, as far as anyone knows; the
+	// time the device was unreachable isn't counted
+	var elapsed time.Duration
+	if d.Seen && d.Online {
+		elapsed = now.Sub(d.LastUpdate)
+	}
+	addDoorStateTime(labels, d.Status.GarageDoorState, elapsed)
+
+	var events []Event
+	if d.Seen {
+		changed := func(typ, from, to string) {
+			if from != to {
+				events = append(events, Event{Time: now, Device: name, Type: typ, From: from, To: to})
+			}
+		}
+		if !d.Online {
+			changed("connectivity", "offline", "online")
+		}
+		changed("door", d.Status.GarageDoorState, status.GarageDoorState)
+		changed("light", onOff(d.Status.GarageLightOn), onOff(status.GarageLightOn))
+		changed("motion", onOff(d.Status.GarageMotion), onOff(status.GarageMotion))
+		changed("obstruction", onOff(d.Status.GarageObstructed), onOff(status.GarageObstructed))
+
+		if status.GarageObstructed && !d.Status.GarageObstructed {
+			d.Obstructions = append(d.Obstructions, now)
+			d.ObstructionCount++
+			obstructionsTotal.WithLabelValues(labels...).Inc()
+			countersChanged = true
+		}
+		for len(d.Obstructions) > 0 && now.Sub(d.Obstructions[0]) > 24*time.Hour {
+			d.Obstructions = d.Obstructions[1:]
+		}
+
+		if status.GarageDoorState != d.Status.GarageDoorState {
+			d.PreviousDoorState = d.Status.GarageDoorState
+		}
+		// the door stopped or turned around while closing, and the beam was
+		// blocked at some point since it started moving
+		if d.Status.GarageDoorState == "Closing" && status.GarageDoorState != "Closing" && status.GarageDoorState != "Closed" &&
+			(status.GarageObstructed || d.Status.GarageObstructed || d.obstructionsSince(d.TransitionSince) > 0) {
+			d.CloseReversals++
+			closeReversals.WithLabelValues(labels...).Inc()
+			countersChanged = true
+		}
+		if status.GarageDoorState == "Closed" && d.Status.GarageDoorState != "Closed" {
+			d.Cycles++
+			doorCycles.WithLabelValues(labels...).Inc()
+			countersChanged = true
+		}
+	}
+
+	if !inTransition(status.GarageDoorState) {
+		d.TransitionSince = time.Time{}
+	} else if d.TransitionSince.IsZero() {
+		d.TransitionSince = now
+	}
+	if status.GarageDoorState == "Closed" {
+		d.OpenSince = time.Time{}
+	} else if d.OpenSince.IsZero() {
+		d.OpenSince = now
+	}
+
+	d.Status = status
+	d.Seen = true
+	d.Online = true
+	d.Failures = 0
+	d.LastUpdate = now
+
+	if countersChanged {
+		saveCounters(d)
+	}
+	dispatch(d, events)
+}
+
+func recordFailure(d *deviceState, start time.Time, err error) {
+	name := d.Name
+	now := time.Now()
+	d.LastFetch = start
+	d.FetchDuration = now.Sub(start)
+	d.LastError = err.Error()
+	d.Failures++
+
+	var events []Event
+	if d.Online {
+		events = append(events, Event{Time: now, Device: name, Type: "connectivity", From: "online", To: "offline"})
+	}
+	d.Online = false
+
+	dispatch(d, events)
+}
+
+func dispatch(d *deviceState, events []Event) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if d.Status.DeviceName != "" {
+		deviceNames[d.Name] = d.Status.DeviceName
+	}
+	for _, e := range events {
+		for _, f := range eventHandlers {
+			f(e)
+		}
+	}
+	for _, f := range updateHandlers {
+		f(d)
+	}
+}
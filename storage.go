@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	storagePath      string
+	storageRetention time.Duration
+	// nil unless -storage.path is set
+	db *sql.DB
+)
+
+func init() {
+	flag.StringVar(&storagePath, "storage.path", "", "SQLite database to keep the event history in, so it survives restarts (in memory only when empty)")
+	flag.DurationVar(&storageRetention, "storage.retention", 0, "Delete stored events older than this, after rolling them up into daily counts that are kept forever (0 keeps everything)")
+
+	onEvent(func(e Event) {
+		if db == nil {
+			return
+		}
+		_, err := db.Exec(`INSERT INTO events (time, device, type, from_state, to_state) VALUES (?, ?, ?, ?, ?)`,
+			e.Time.UnixMilli(), e.Device, e.Type, e.From, e.To)
+		if err != nil {
+			slog.Error("Error storing event", "device", e.Device, "err", err)
+		}
+	})
+}
+
+func openStorage() error {
+	if storagePath == "" {
+		return nil
+	}
+	d, err := sql.Open("sqlite", storagePath+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return err
+	}
+	// SQLite only has one writer anyway
+	d.SetMaxOpenConns(1)
+	_, err = d.Exec(`
+CREATE TABLE IF NOT EXISTS events (
+	time INTEGER NOT NULL,
+	device TEXT NOT NULL,
+	type TEXT NOT NULL,
+	from_state TEXT NOT NULL,
+	to_state TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+CREATE TABLE IF NOT EXISTS daily (
+	day TEXT NOT NULL,
+	device TEXT NOT NULL,
+	type TEXT NOT NULL,
+	to_state TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	PRIMARY KEY (day, device, type, to_state)
+);
+CREATE TABLE IF NOT EXISTS counters (
+	device TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value INTEGER NOT NULL,
+	PRIMARY KEY (device, name)
+);`)
+	if err != nil {
+		d.Close()
+		return err
+	}
+	db = d
+	slog.Info("Storing events", "path", storagePath)
+	var first sql.NullInt64
+	if err := db.QueryRow("SELECT min(time) FROM events").Scan(&first); err == nil && first.Valid {
+		observedSince = time.UnixMilli(first.Int64)
+	}
+	go compactStorage()
+	return nil
+}
+
+// startOfDay is midnight before t in local time.
+func startOfDay(t time.Time) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+}
+
+// compactStorage rolls up finished days of events into daily counts and deletes
+// events past the retention, every hour. Events are only deleted by whole days
+// so the rollups of the days that are left stay complete.
+func compactStorage() {
+	defer reportPanic()
+	for {
+		today := startOfDay(time.Now())
+		_, err := db.Exec(`INSERT OR REPLACE INTO daily (day, device, type, to_state, count)
+SELECT date(time / 1000, 'unixepoch', 'localtime'), device, type, to_state, count(*)
+FROM events WHERE time < ? GROUP BY 1, 2, 3, 4`, today.UnixMilli())
+		if err != nil {
+			slog.Error("Error rolling up events", "err", err)
+		} else if storageRetention > 0 {
+			cutoff := startOfDay(time.Now().Add(-storageRetention))
+			result, err := db.Exec("DELETE FROM events WHERE time < ?", cutoff.UnixMilli())
+			if err != nil {
+				slog.Error("Error deleting old events", "err", err)
+			} else if n, _ := result.RowsAffected(); n > 0 {
+				slog.Info("Deleted old events", "count", n, "before", cutoff.Format("2006-01-02"))
+				if _, err := db.Exec("VACUUM"); err != nil {
+					slog.Error("Error compacting storage", "err", err)
+				}
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func inClause(column string, values map[string]bool, args *[]interface{}) string {
+	var marks []string
+	for v := range values {
+		marks = append(marks, "?")
+		*args = append(*args, v)
+	}
+	return column + " IN (" + strings.Join(marks, ", ") + ")"
+}
+
+// storedEvents returns the stored events matching q, newest first.
+func storedEvents(q eventQuery) ([]Event, error) {
+	var where []string
+	var args []interface{}
+	if q.devices != nil {
+		where = append(where, inClause("device", q.devices, &args))
+	}
+	if q.types != nil {
+		where = append(where, inClause("type", q.types, &args))
+	}
+	if !q.since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.since.UnixMilli())
+	}
+	if !q.until.IsZero() {
+		where = append(where, "time <= ?")
+		args = append(args, q.until.UnixMilli())
+	}
+	query := "SELECT time, device, type, from_state, to_state FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC"
+	if q.limit >= 0 {
+		query += " LIMIT ?"
+		args = append(args, q.limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var ms int64
+		if err := rows.Scan(&ms, &e.Device, &e.Type, &e.From, &e.To); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(ms).UTC()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// counterFields are the exporter-derived counters of a device that are kept across restarts.
+func counterFields(d *deviceState) map[string]*int {
+	return map[string]*int{
+		"door_cycles":     &d.Cycles,
+		"obstructions":    &d.ObstructionCount,
+		"crashes":         &d.Crashes,
+		"crash_count":     &d.LastCrashCount,
+		"close_reversals": &d.CloseReversals,
+	}
+}
+
+// loadCounters restores the counters of the known devices.
+func loadCounters() error {
+	for _, d := range allDevices() {
+		if err := loadDeviceCounters(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadDeviceCounters restores the counters of a device, also one that is
+// added later, so they aren't overwritten with zeros on its first fetch.
+func loadDeviceCounters(d *deviceState) error {
+	if db == nil {
+		return nil
+	}
+	rows, err := db.Query("SELECT name, value FROM counters WHERE device = ?", d.Name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for rows.Next() {
+		var name string
+		var value int
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		if field, ok := counterFields(d)[name]; ok {
+			*field = value
+		}
+		if name == "crash_count" {
+			d.crashesKnown = true
+		}
+	}
+	return rows.Err()
+}
+
+func saveCounters(d *deviceState) {
+	if db == nil {
+		return
+	}
+	for name, field := range counterFields(d) {
+		_, err := db.Exec("INSERT OR REPLACE INTO counters (device, name, value) VALUES (?, ?, ?)", d.Name, name, *field)
+		if err != nil {
+			slog.Error("Error storing counters", "device", d.Name, "err", err)
+			return
+		}
+	}
+}
+
+// dailyCount is how often a device made a transition on one day.
+type dailyCount struct {
+	Day    string `json:"day"`
+	Device string `json:"device"`
+	Type   string `json:"type"`
+	To     string `json:"to"`
+	Count  int    `json:"count"`
+}
+
+// dailyCounts returns the rolled up counts of finished days matching q, oldest first.
+func dailyCounts(q eventQuery) ([]dailyCount, error) {
+	var where []string
+	var args []interface{}
+	if q.devices != nil {
+		where = append(where, inClause("device", q.devices, &args))
+	}
+	if q.types != nil {
+		where = append(where, inClause("type", q.types, &args))
+	}
+	if !q.since.IsZero() {
+		where = append(where, "day >= ?")
+		args = append(args, q.since.Format("2006-01-02"))
+	}
+	if !q.until.IsZero() {
+		where = append(where, "day <= ?")
+		args = append(args, q.until.Format("2006-01-02"))
+	}
+	query := "SELECT day, device, type, to_state, count FROM daily"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY day, device, type, to_state"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []dailyCount{}
+	for rows.Next() {
+		var c dailyCount
+		if err := rows.Scan(&c.Day, &c.Device, &c.Type, &c.To, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
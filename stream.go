@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// streamMessage is sent to live stream subscribers, either a "status" snapshot
+// of a device or a "transition" Event.
+type streamMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+var (
+	// subscribers and the last status sent per device; guarded by mutex
+	streamSubscribers = map[chan streamMessage]bool{}
+	streamLastStatus  = map[string]string{}
+)
+
+func init() {
+	onEvent(func(e Event) {
+		broadcast(streamMessage{Type: "transition", Data: e})
+	})
+	onUpdate(func(d *deviceState) {
+		s := snapshot(d)
+		key := changeKey(s)
+		if streamLastStatus[d.Name] == key {
+			return
+		}
+		streamLastStatus[d.Name] = key
+		broadcast(streamMessage{Type: "status", Data: s})
+	})
+}
+
+// changeKey ignores the fields that change on every fetch, so only real changes are streamed.
+func changeKey(s statusSnapshot) string {
+	s.LastUpdate = nil
+	s.LastFetch = nil
+	s.FetchDurationSeconds = 0
+	s.OpenDurationSeconds = 0
+	if s.Status != nil {
+		status := *s.Status
+		status.UpTime = 0
+		s.Status = &status
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func broadcast(m streamMessage) {
+	for c := range streamSubscribers {
+		select {
+		case c <- m:
+		default:
+			// the subscriber is too slow, drop the message rather than block fetches
+		}
+	}
+}
+
+// subscribe registers a new subscriber and queues the current status of every device for it.
+func subscribe() chan streamMessage {
+	locked, unlock := lockDevices()
+	defer unlock()
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// room for every device's snapshot, so queuing them can't block with the locks held
+	c := make(chan streamMessage, len(locked)+64)
+	for _, d := range locked {
+		c <- streamMessage{Type: "status", Data: snapshot(d)}
+	}
+	streamSubscribers[c] = true
+	return c
+}
+
+func unsubscribe(c chan streamMessage) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(streamSubscribers, c)
+}
+
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	visible := visibleDevices(r)
+	c := subscribe()
+	defer unsubscribe(c)
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case m := <-c:
+			if visible != nil && !visible[streamDevice(m)] {
+				continue
+			}
+			data, err := json.Marshal(m.Data)
+			if err != nil {
+				slog.Error("Error marshalling stream message", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, data)
+		}
+		flusher.Flush()
+	}
+}
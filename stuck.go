@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stuckAfter time.Duration
+
+	doorStuck = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_door_stuck",
+		Help: "1 if the door has been opening, closing or stopped for longer than -door.stuck-after.",
+	}, []string{"location", "device", "displayName"})
+)
+
+func init() {
+	flag.DurationVar(&stuckAfter, "door.stuck-after", time.Minute, "How long a door may be opening, closing or stopped before homekit_ratgdo_door_stuck is 1")
+
+	prometheus.MustRegister(doorStuck)
+	onUpdate(setDoorStuck)
+}
+
+// inTransition is whether the door is neither open nor closed.
+func inTransition(state string) bool {
+	return state == "Opening" || state == "Closing" || state == "Stopped"
+}
+
+// stuck is whether the door has been in transition for longer than after.
+func (d *deviceState) stuck(after time.Duration) bool {
+	return d.Online && inTransition(d.Status.GarageDoorState) && time.Since(d.TransitionSince) > after
+}
+
+func setDoorStuck(d *deviceState) {
+	// an unreachable device keeps its last value, like its other series
+	if !d.Online {
+		return
+	}
+	doorStuck.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name)).Set(boolToFloat(d.stuck(stuckAfter)))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Coordinates are where devices are on earth, to tell when it's dark there.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+var doorOpenAfterDark = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "homekit_ratgdo_door_open_after_dark",
+	Help: "1 if the door isn't closed and the sun has set where the device is, only for devices with coordinates.",
+}, []string{"location", "device", "displayName"})
+
+func init() {
+	prometheus.MustRegister(doorOpenAfterDark)
+	onUpdate(setDoorOpenAfterDark)
+}
+
+func (c *Coordinates) validate() error {
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// targetCoordinates are the coordinates of a device in the config file, its
+// own or those of all devices, nil when there are none.
+func targetCoordinates(name string) *Coordinates {
+	cfg := currentConfig()
+	if c := cfg.Targets[name].Coordinates; c != nil {
+		return c
+	}
+	return cfg.Coordinates
+}
+
+// sunElevation is the angle of the sun's center above the horizon in degrees,
+// good to about a tenth of a degree.
+func (c *Coordinates) sunElevation(t time.Time) float64 {
+	rad := math.Pi / 180
+	// days since J2000
+	n := float64(t.UnixMilli())/86400000 + 2440587.5 - 2451545
+	meanLongitude := 280.460 + 0.9856474*n
+	meanAnomaly := (357.528 + 0.9856003*n) * rad
+	eclipticLongitude := (meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly)) * rad
+	obliquity := (23.439 - 0.0000004*n) * rad
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+	siderealTime := (280.46061837 + 360.98564736629*n + c.Longitude) * rad
+	hourAngle := siderealTime - rightAscension
+	latitude := c.Latitude * rad
+	return math.Asin(math.Sin(latitude)*math.Sin(declination)+math.Cos(latitude)*math.Cos(declination)*math.Cos(hourAngle)) / rad
+}
+
+// dark is whether t is between sunset and sunrise, when the sun's upper edge
+// is below the horizon, refraction included.
+func (c *Coordinates) dark(t time.Time) bool {
+	return c.sunElevation(t) < -0.833
+}
+
+// openAfterDark is whether the door of a device with coordinates is open at night.
+func (d *deviceState) openAfterDark() bool {
+	c := targetCoordinates(d.Name)
+	return c != nil && d.Online && d.Status.GarageDoorState != "Closed" && c.dark(time.Now())
+}
+
+func setDoorOpenAfterDark(d *deviceState) {
+	if !d.Online || targetCoordinates(d.Name) == nil {
+		return
+	}
+	doorOpenAfterDark.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name)).Set(boolToFloat(d.openAfterDark()))
+}
+
+// hasCoordinates is whether any device has coordinates.
+func (c *Config) hasCoordinates() bool {
+	if c.Coordinates != nil {
+		return true
+	}
+	for _, t := range c.Targets {
+		if t.Coordinates != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var (
+	greenwich = Coordinates{Latitude: 51.4769, Longitude: 0}
+	newYork   = Coordinates{Latitude: 40.7128, Longitude: -74.006}
+	sydney    = Coordinates{Latitude: -33.8688, Longitude: 151.2093}
+	tromso    = Coordinates{Latitude: 69.6492, Longitude: 18.9553}
+)
+
+func utc(t *testing.T, s string) time.Time {
+	u, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// TestSunElevation checks against the NOAA solar calculator, without refraction.
+func TestSunElevation(t *testing.T) {
+	tests := []struct {
+		c    Coordinates
+		t    string
+		want float64
+	}{
+		{greenwich, "2024-06-21 12:00", 61.958},
+		{greenwich, "2024-12-21 12:00", 15.084},
+		{Coordinates{}, "2024-03-20 12:00", 88.171},
+		{sydney, "2024-06-21 02:00", 32.688},
+		{sydney, "2024-06-21 14:00", -79.549},
+		// the midnight sun and the polar night
+		{tromso, "2024-06-21 23:00", 3.117},
+		{tromso, "2024-12-21 11:00", -3.141},
+	}
+	for _, tt := range tests {
+		if got := tt.c.sunElevation(utc(t, tt.t)); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%+v at %s: got %.3f, want %.3f", tt.c, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestDark(t *testing.T) {
+	// sunrise in New York on June 21, 2024 is at 5:25 EDT and sunset at 20:31 EDT
+	tests := []struct {
+		t    string
+		want bool
+	}{
+		{"2024-06-21 09:15", true},
+		{"2024-06-21 09:35", false},
+		{"2024-06-21 16:00", false},
+		{"2024-06-22 00:20", false},
+		{"2024-06-22 00:45", true},
+		{"2024-06-22 05:00", true},
+	}
+	for _, tt := range tests {
+		if got := newYork.dark(utc(t, tt.t)); got != tt.want {
+			t.Errorf("%s UTC: got %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
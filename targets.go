@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Target is a ratgdo device the exporter fetches status.json from.
+type Target struct {
+	Name string
+	URL  string
+	// Source is where the target comes from: flag, config or kubernetes.
+	Source string
+	// Interval overrides -poll-interval for the target.
+	Interval time.Duration
+}
+
+// TargetConfig are the config file's settings for one device, by its name.
+type TargetConfig struct {
+	// Location overrides -location for the device.
+	Location string `json:"location"`
+	// DisplayName is the device's name in the metrics' displayName label,
+	// notifications and the API, instead of the firmware's deviceName.
+	DisplayName string `json:"displayName"`
+	// URL adds the device as a target, like it was given in -json-address.
+	URL string `json:"url"`
+	// PollInterval overrides -poll-interval for the device.
+	PollInterval duration `json:"pollInterval"`
+	// Maintenance is the maintenance mode of the device: off, on or pause.
+	Maintenance string `json:"maintenance"`
+	// Coordinates override the config's coordinates for the device.
+	Coordinates *Coordinates `json:"coordinates"`
+	// Headers are sent with every request to the device, like for a proxy
+	// in front of it; Host sets the host of the requests.
+	Headers map[string]string `json:"headers"`
+	// UserAgent replaces the exporter's User-Agent in requests to the device.
+	UserAgent string `json:"userAgent"`
+	// Login logs in to devices that want a session cookie.
+	Login *LoginConfig `json:"login"`
+}
+
+func (t TargetConfig) validate() error {
+	if t.PollInterval < 0 {
+		return fmt.Errorf("invalid pollInterval %s", time.Duration(t.PollInterval))
+	}
+	if t.Maintenance != "" && !validMaintenance(t.Maintenance) {
+		return fmt.Errorf("invalid maintenance %q, use off, on or pause", t.Maintenance)
+	}
+	if t.Coordinates != nil {
+		if err := t.Coordinates.validate(); err != nil {
+			return err
+		}
+	}
+	if t.Login != nil {
+		if err := t.Login.validate(); err != nil {
+			return fmt.Errorf("login: %v", err)
+		}
+	}
+	for k, v := range t.Headers {
+		if k == "" || strings.ContainsAny(k, " :\r\n") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid header %q", k)
+		}
+	}
+	if t.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(t.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", t.URL)
+	}
+	return nil
+}
+
+var (
+	targetsMutex sync.Mutex
+	// replaced as a whole when they change; guarded by targetsMutex
+	targets []Target
+	// cancel the poll loops of the targets once polling started; guarded by targetsMutex
+	pollers map[string]context.CancelFunc
+
+	// serializes updateTargets, so the last update wins
+	updateMutex sync.Mutex
+	// the targets found by discovery; guarded by updateMutex
+	discovered []Target
+
+	shardIndex int
+	shardTotal int
+)
+
+func init() {
+	flag.IntVar(&shardIndex, "shard.index", 0, "Which of the -shard.total shards of the devices this exporter watches, from 0")
+	flag.IntVar(&shardTotal, "shard.total", 1, "Split the devices between this many exporters, each given all of them with its own -shard.index")
+}
+
+// pollInterval is how often the target is polled, 0 when it isn't.
+func (t Target) pollInterval() time.Duration {
+	if t.Interval > 0 {
+		return t.Interval
+	}
+	return pollInterval
+}
+
+// endpoint is the URL of another path on the device, like /reboot.
+func (t Target) endpoint(path string) string {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return t.URL
+	}
+	u.Path, u.RawQuery = path, ""
+	return u.String()
+}
+
+// newRequest is a request to the device, with the User-Agent and headers of
+// its config.
+func (t Target) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	cfg := currentConfig().Targets[t.Name]
+	req.Header.Set("User-Agent", "homekit-ratgdo-exporter/"+version)
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+	for k, v := range cfg.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// targetLocation is the location label of a device.
+func targetLocation(name string) string {
+	if l := currentConfig().Targets[name].Location; l != "" {
+		return l
+	}
+	return location
+}
+
+// targetDisplayName is the displayName label of a device, its name in the
+// exporter unless the config file sets one.
+func targetDisplayName(name string) string {
+	if n := currentConfig().Targets[name].DisplayName; n != "" {
+		return n
+	}
+	return name
+}
+
+// deviceLabelNames are the labels of the series of a device's status.
+var deviceLabelNames = []string{"location", "accessoryID", "deviceName", "displayName", "localIP", "macAddress"}
+
+// deviceLabels are the values of deviceLabelNames for a device.
+func deviceLabels(name string, s Status) []string {
+	return []string{targetLocation(name), s.AccessoryID, s.DeviceName, targetDisplayName(name), s.LocalIP, s.MacAddress}
+}
+
+// currentTargets returns the targets. The slice must not be changed.
+func currentTargets() []Target {
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	return targets
+}
+
+// setTargets makes all, or this exporter's shard of them, the devices it
+// watches. New ones are added and polled, those that are gone are removed
+// with their series.
+func setTargets(all []Target) error {
+	mine, err := shard(all)
+	if err != nil {
+		return err
+	}
+
+	// the series of removed devices are deleted once targetsMutex is released,
+	// as the update handlers take it with the device's mu held
+	var gone []*deviceState
+	defer func() {
+		for _, d := range gone {
+			removeDevice(d)
+		}
+	}()
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	previous := map[string]Target{}
+	for _, t := range targets {
+		previous[t.Name] = t
+	}
+	old := allDevices()
+	next := map[string]*deviceState{}
+	wanted := map[string]Target{}
+	var added, removed []string
+	for _, t := range mine {
+		wanted[t.Name] = t
+		if d, ok := old[t.Name]; ok {
+			next[t.Name] = d
+		} else {
+			d := &deviceState{Name: t.Name}
+			if err := loadDeviceCounters(d); err != nil {
+				slog.Error("Error loading counters", "device", t.Name, "err", err)
+			}
+			next[t.Name] = d
+			added = append(added, t.Name)
+		}
+	}
+	deviceMap.Store(&next)
+	targets = mine
+	for name, d := range old {
+		if _, ok := next[name]; !ok {
+			removed = append(removed, name)
+			gone = append(gone, d)
+		}
+	}
+	if old != nil && (len(added) > 0 || len(removed) > 0) {
+		slog.Info("Devices changed", "added", added, "removed", removed)
+	}
+	setMaintenanceMetrics(mine)
+
+	if pollers == nil {
+		return nil
+	}
+	// a target whose address changed starts over
+	for name, cancel := range pollers {
+		if wanted[name] != previous[name] {
+			cancel()
+			delete(pollers, name)
+		}
+	}
+	for _, t := range mine {
+		if interval := t.pollInterval(); interval > 0 && pollers[t.Name] == nil {
+			startPoller(t, time.Duration(rand.Int63n(int64(interval))))
+		}
+	}
+	return nil
+}
+
+// removeDevice deletes the series of a device that isn't watched anymore.
+func removeDevice(d *deviceState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	byName := prometheus.Labels{"device": d.Name}
+	var byLabels prometheus.Labels
+	if d.labels != nil {
+		byLabels = prometheus.Labels{"location": d.labels[0], "accessoryID": d.labels[1], "displayName": d.labels[3]}
+	}
+	for _, v := range deviceSeries() {
+		v.DeletePartialMatch(byName)
+		if byLabels != nil {
+			v.DeletePartialMatch(byLabels)
+		}
+	}
+	setDeviceInfo(d, nil)
+}
+
+// deviceSeries are the metrics with series of single devices.
+func deviceSeries() []interface{ DeletePartialMatch(prometheus.Labels) int } {
+	return []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		upTime, paired, garageLightOn, garageMotion, garageObstructed, passwordRequired, freeHeap, minHeap, minStack,
+		crashCount, garageDoorState, doorStatus, doorStateSeconds, doorOpenSeconds, doorCycles, obstructionsTotal, crashesTotal,
+		closeReversals, heapExhaustion, deviceUp, firmwareOutdated, alertActive, autoCloses, actuations, watchdogReboots, maintenanceGauge,
+		doorTravel, doorStuck, unexplainedMotion, doorOpenAfterDark, sharedFetches, cachedFetches, cancelledFetches, staleFetches, pollerRestarts, fetchedBytes, logins,
+	}
+}
+
+func findTarget(name string) (Target, bool) {
+	for _, t := range currentTargets() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// updateTargets sets the static targets of the config and the discovered
+// ones, the static ones winning when both have the same name.
+func updateTargets() error {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	return setTargets(mergeTargets())
+}
+
+// mergeTargets returns the static and discovered targets; it runs with updateMutex held.
+func mergeTargets() []Target {
+	all, err := staticTargets(currentConfig())
+	if err != nil {
+		// checked when the config was loaded
+		slog.Error("Error parsing the devices", "err", err)
+	}
+	static := map[string]bool{}
+	for _, t := range all {
+		static[t.Name] = true
+	}
+	for _, t := range discovered {
+		if static[t.Name] {
+			slog.Warn("Discovered device has the name of a configured one, ignoring it", "device", t.Name, "url", t.URL)
+			continue
+		}
+		all = append(all, t)
+	}
+	return all
+}
+
+// setDiscovered replaces the discovered targets.
+func setDiscovered(ts []Target) error {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+	discovered = ts
+	return setTargets(mergeTargets())
+}
+
+// staticTargets are the targets of -json-address and those with a url in the
+// config. The default -json-address is only used when there are no others.
+func staticTargets(c *Config) ([]Target, error) {
+	var names []string
+	for name, t := range c.Targets {
+		if t.URL != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	jsonAddressSet := false
+	flag.Visit(func(f *flag.Flag) {
+		jsonAddressSet = jsonAddressSet || f.Name == "json-address"
+	})
+
+	var all []Target
+	seen := map[string]bool{}
+	if len(names) == 0 && !kubernetesDiscovery || jsonAddressSet {
+		var err error
+		if all, err = parseTargets(jsonAddress); err != nil {
+			return nil, fmt.Errorf("-json-address: %v", err)
+		}
+		for i := range all {
+			all[i].Source = "flag"
+			all[i].Interval = time.Duration(c.Targets[all[i].Name].PollInterval)
+			seen[all[i].Name] = true
+		}
+	}
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate device name %q", name)
+		}
+		t := c.Targets[name]
+		all = append(all, Target{Name: name, URL: t.URL, Source: "config", Interval: time.Duration(t.PollInterval)})
+	}
+	return all, nil
+}
+
+// parseTargets parses a comma separated list of [name=]address. Without a name
+// the host of the address is used.
+func parseTargets(s string) ([]Target, error) {
+	var result []Target
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var t Target
+		if i := strings.Index(entry, "="); i > 0 && !strings.Contains(entry[:i], "/") {
+			t.Name, t.URL = entry[:i], entry[i+1:]
+		} else {
+			t.URL = entry
+		}
+		u, err := url.Parse(t.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid address %q", t.URL)
+		}
+		if t.Name == "" {
+			t.Name = u.Hostname()
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("duplicate device name %q", t.Name)
+		}
+		seen[t.Name] = true
+		result = append(result, t)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no addresses given")
+	}
+	return result, nil
+}
+
+// shard returns the targets of this exporter's shard. Every target goes to
+// one shard by the hash of its name, so the exporters split them the same way
+// whatever order they are given in.
+func shard(all []Target) ([]Target, error) {
+	if shardTotal < 1 || shardIndex < 0 || shardIndex >= shardTotal {
+		return nil, fmt.Errorf("-shard.index has to be between 0 and -shard.total %d", shardTotal)
+	}
+	var result []Target
+	for _, t := range all {
+		sum := sha256.Sum256([]byte(t.Name))
+		if binary.BigEndian.Uint64(sum[:])%uint64(shardTotal) == uint64(shardIndex) {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Target
+		wantErr bool
+	}{
+		{
+			in:   "http://10.0.0.5/status.json",
+			want: []Target{{Name: "10.0.0.5", URL: "http://10.0.0.5/status.json"}},
+		},
+		{
+			in: " left=http://10.0.0.5/status.json, right=http://10.0.0.6:8080/status.json ,",
+			want: []Target{
+				{Name: "left", URL: "http://10.0.0.5/status.json"},
+				{Name: "right", URL: "http://10.0.0.6:8080/status.json"},
+			},
+		},
+		// an = in the query isn't a name
+		{
+			in:   "http://10.0.0.5/status.json?a=b",
+			want: []Target{{Name: "10.0.0.5", URL: "http://10.0.0.5/status.json?a=b"}},
+		},
+		{in: "", wantErr: true},
+		{in: "10.0.0.5", wantErr: true},
+		{in: "left=", wantErr: true},
+		{in: "http://10.0.0.5/status.json,http://10.0.0.5/other.json", wantErr: true},
+		{in: "a=http://10.0.0.5/status.json,a=http://10.0.0.6/status.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTargets(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTargets(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTargets(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestShard(t *testing.T) {
+	defer func(index, total int) { shardIndex, shardTotal = index, total }(shardIndex, shardTotal)
+
+	var all []Target
+	for _, name := range []string{"garage", "barn", "shop", "carport", "left", "right"} {
+		all = append(all, Target{Name: name})
+	}
+	// the split must not change between versions, or exporters being upgraded
+	// one by one would watch some devices twice and others not at all
+	want := [][]string{{"garage", "carport"}, {"shop", "right"}, {"barn", "left"}}
+	shardTotal = 3
+	for i, names := range want {
+		shardIndex = i
+		got, err := shard(all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotNames []string
+		for _, t := range got {
+			gotNames = append(gotNames, t.Name)
+		}
+		if !reflect.DeepEqual(gotNames, names) {
+			t.Errorf("shard %d = %v, want %v", i, gotNames, names)
+		}
+	}
+
+	reversed := make([]Target, len(all))
+	for i, t := range all {
+		reversed[len(all)-1-i] = t
+	}
+	shardIndex = 1
+	if got, _ := shard(reversed); len(got) != 2 || got[0].Name != "right" || got[1].Name != "shop" {
+		t.Errorf("shard 1 of the reversed devices = %v, want right and shop", got)
+	}
+
+	shardIndex, shardTotal = 0, 1
+	if got, err := shard(all); err != nil || len(got) != len(all) {
+		t.Errorf("a single shard has %d of %d devices, %v", len(got), len(all), err)
+	}
+}
+
+func TestShardInvalid(t *testing.T) {
+	defer func(index, total int) { shardIndex, shardTotal = index, total }(shardIndex, shardTotal)
+
+	for _, tt := range []struct{ index, total int }{{0, 0}, {-1, 2}, {2, 2}, {5, 1}, {-1, 1}} {
+		shardIndex, shardTotal = tt.index, tt.total
+		if _, err := shard([]Target{{Name: "garage"}}); err == nil {
+			t.Errorf("shard %d of %d: no error", tt.index, tt.total)
+		}
+	}
+}
+
+func TestTargetPollInterval(t *testing.T) {
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = time.Minute
+
+	tests := []struct {
+		interval time.Duration
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{10 * time.Second, 10 * time.Second},
+		{time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := (Target{Interval: tt.interval}).pollInterval(); got != tt.want {
+			t.Errorf("interval %s: got %s, want %s", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestStaticTargets(t *testing.T) {
+	defer func(address string, discovery bool, flags *flag.FlagSet) {
+		jsonAddress, kubernetesDiscovery, flag.CommandLine = address, discovery, flags
+	}(jsonAddress, kubernetesDiscovery, flag.CommandLine)
+
+	config := func(targets map[string]TargetConfig) *Config {
+		return &Config{Targets: targets}
+	}
+	tests := []struct {
+		name      string
+		set       bool
+		discovery bool
+		config    *Config
+		want      []Target
+		wantErr   bool
+	}{
+		{
+			name:   "default address",
+			config: config(map[string]TargetConfig{"garage": {PollInterval: duration(30 * time.Second)}}),
+			want:   []Target{{Name: "garage", URL: "http://garage/status.json", Source: "flag", Interval: 30 * time.Second}},
+		},
+		{
+			name: "config replaces the default address",
+			config: config(map[string]TargetConfig{
+				"shop": {URL: "http://10.0.0.6/status.json"},
+				"barn": {URL: "http://10.0.0.5/status.json", PollInterval: duration(time.Minute)},
+				"left": {Location: "home"},
+			}),
+			want: []Target{
+				{Name: "barn", URL: "http://10.0.0.5/status.json", Source: "config", Interval: time.Minute},
+				{Name: "shop", URL: "http://10.0.0.6/status.json", Source: "config"},
+			},
+		},
+		{
+			name:      "discovery replaces the default address",
+			discovery: true,
+			config:    config(nil),
+		},
+		{
+			name:      "set address",
+			set:       true,
+			discovery: true,
+			config:    config(map[string]TargetConfig{"barn": {URL: "http://10.0.0.5/status.json"}}),
+			want: []Target{
+				{Name: "garage", URL: "http://garage/status.json", Source: "flag"},
+				{Name: "barn", URL: "http://10.0.0.5/status.json", Source: "config"},
+			},
+		},
+		{
+			name:    "duplicate name",
+			set:     true,
+			config:  config(map[string]TargetConfig{"garage": {URL: "http://10.0.0.5/status.json"}}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		// a new flag set, as a flag can't be unset
+		flag.CommandLine = flag.NewFlagSet("test", flag.PanicOnError)
+		flag.StringVar(&jsonAddress, "json-address", "http://garage/status.json", "")
+		kubernetesDiscovery = tt.discovery
+		if tt.set {
+			flag.Set("json-address", jsonAddress)
+		}
+		got, err := staticTargets(tt.config)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, %v, want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
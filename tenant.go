@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TenantConfig is someone sharing the exporter, who owns some of its devices
+// and only sees those.
+type TenantConfig struct {
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"`
+	// APITokens and Notifiers are like the top-level ones, limited to the tenant's devices.
+	APITokens []APIToken       `json:"apiTokens"`
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// validateTenants adds the tokens of the tenants to c, limited to their devices.
+func (c *Config) validateTenants() error {
+	c.tenants = map[string]string{}
+	for i := range c.Tenants {
+		t := &c.Tenants[i]
+		if t.Name == "" {
+			return fmt.Errorf("tenant %d: name is required", i+1)
+		}
+		if len(t.Devices) == 0 {
+			return fmt.Errorf("tenant %s: devices are required", t.Name)
+		}
+		for _, d := range t.Devices {
+			if owner, ok := c.tenants[d]; ok {
+				return fmt.Errorf("tenant %s: device %s belongs to tenant %s already", t.Name, d, owner)
+			}
+			c.tenants[d] = t.Name
+		}
+		for j := range t.APITokens {
+			token := t.APITokens[j]
+			if err := token.validate(); err != nil {
+				return fmt.Errorf("tenant %s: API token %d: %v", t.Name, j+1, err)
+			}
+			if slices.Contains(token.Scopes, scopeAdmin) {
+				return fmt.Errorf("tenant %s: API token %s: tenants can't have the admin scope", t.Name, token.Name)
+			}
+			for _, d := range token.Devices {
+				if c.tenants[d] != t.Name {
+					return fmt.Errorf("tenant %s: API token %s: device %s isn't the tenant's", t.Name, token.Name, d)
+				}
+			}
+			if len(token.Devices) == 0 {
+				token.Devices = t.Devices
+			}
+			c.APITokens = append(c.APITokens, token)
+		}
+		for j := range t.Notifiers {
+			if err := t.Notifiers[j].validate(c.QuietHours); err != nil {
+				return fmt.Errorf("tenant %s: notifier %d: %v", t.Name, j+1, err)
+			}
+		}
+	}
+	// the tenants' API has to be closed to the others
+	if len(c.Tenants) > 0 {
+		c.ProtectRead = true
+	}
+	return nil
+}
+
+// visibleDevices returns the devices the request's token is limited to, which
+// for a tenant's token are at most the tenant's, nil when it may see all of them.
+func visibleDevices(r *http.Request) map[string]bool {
+	t := authenticate(r)
+	if t == nil || len(t.Devices) == 0 {
+		return nil
+	}
+	visible := map[string]bool{}
+	for _, d := range t.Devices {
+		visible[d] = true
+	}
+	return visible
+}
+
+// restrict limits the query to the visible devices.
+func (q *eventQuery) restrict(visible map[string]bool) {
+	if visible == nil {
+		return
+	}
+	if q.devices == nil {
+		q.devices = visible
+		return
+	}
+	for d := range q.devices {
+		if !visible[d] {
+			delete(q.devices, d)
+		}
+	}
+}
+
+// streamDevice is the device a stream message is about.
+func streamDevice(m streamMessage) string {
+	switch data := m.Data.(type) {
+	case statusSnapshot:
+		return data.Device
+	case Event:
+		return data.Device
+	}
+	return ""
+}
+
+// visibleMetrics serves only the series of the visible devices: those with
+// their names in the device label, or the accessoryID of one of them.
+func visibleMetrics(visible map[string]bool) http.Handler {
+	return promhttp.HandlerFor(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		names, accessories := map[string]bool{}, map[string]bool{}
+		for name, d := range allDevices() {
+			if !visible[name] {
+				continue
+			}
+			names[name] = true
+			d.mu.Lock()
+			if d.Seen {
+				accessories[d.Status.AccessoryID] = true
+			}
+			d.mu.Unlock()
+		}
+		var result []*dto.MetricFamily
+		for _, f := range families {
+			var metrics []*dto.Metric
+			for _, m := range f.Metric {
+				for _, l := range m.Label {
+					if l.GetName() == "device" && names[l.GetValue()] || l.GetName() == "accessoryID" && accessories[l.GetValue()] {
+						metrics = append(metrics, m)
+						break
+					}
+				}
+			}
+			if len(metrics) > 0 {
+				f.Metric = metrics
+				result = append(result, f)
+			}
+		}
+		return result, err
+	}), promhttp.HandlerOpts{})
+}
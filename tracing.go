@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracingEndpoint    string
+	tracingSampleRatio float64
+
+	// spans go nowhere until startTracing sets a provider
+	tracer = otel.Tracer("homekit-ratgdo-exporter")
+)
+
+func init() {
+	flag.StringVar(&tracingEndpoint, "tracing.endpoint", "", "OTLP/HTTP endpoint to send traces of the scrapes to, like http://tempo:4318 (disabled when empty, unless $OTEL_EXPORTER_OTLP_ENDPOINT is set)")
+	flag.Float64Var(&tracingSampleRatio, "tracing.sample-ratio", 1, "Fraction of the requests and polls to trace, from 0 to 1")
+}
+
+// startTracing sets up exporting spans over OTLP/HTTP, if an endpoint is configured.
+func startTracing() error {
+	var opts []otlptracehttp.Option
+	if tracingEndpoint != "" {
+		u, err := url.Parse(tracingEndpoint)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid -tracing.endpoint %q, use like http://tempo:4318", tracingEndpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/traces"
+		}
+		opts = append(opts, otlptracehttp.WithEndpointURL(u.String()))
+	} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "homekit-ratgdo-exporter")))
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingSampleRatio))),
+	))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	slog.Info("Sending traces", "endpoint", tracingEndpoint)
+	return nil
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceRequests starts a span for every request h handles, continuing the
+// caller's trace if it sent a traceparent header.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("client.address", r.RemoteAddr),
+		))
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// travelStart is when a door was first seen opening or closing.
+type travelStart struct {
+	state string
+	at    time.Time
+	// the firmware's clock at the door update and at the fetch, 0 when it doesn't tell
+	firmwareAt int
+	upTime     int64
+	// a fetch failed since, so at is no good for the travel time
+	missed bool
+}
+
+var (
+	doorTravel = prometheus.NewHistogramVec(nativeHistogram(prometheus.HistogramOpts{
+		Name:    "homekit_ratgdo_door_travel_seconds",
+		Help:    "How long the door took from opening to open, or closing to closed, labeled by direction.",
+		Buckets: []float64{5, 8, 10, 12, 14, 16, 18, 20, 25, 30, 45, 60, 120},
+	}), []string{"location", "device", "displayName", "direction"})
+
+	// per device, while its door is moving; guarded by mutex
+	travelStarts = map[string]*travelStart{}
+)
+
+func init() {
+	prometheus.MustRegister(doorTravel)
+	onUpdate(measureTravel)
+}
+
+// measureTravel times the doors between a transitional state and the one it
+// leads to. Only a door seen in both is measured, so the poll interval has to
+// be well below the travel time.
+func measureTravel(d *deviceState) {
+	start := travelStarts[d.Name]
+	if !d.Online {
+		if start != nil {
+			start.missed = true
+		}
+		return
+	}
+	state := d.Status.GarageDoorState
+	if start != nil && start.state == state {
+		return
+	}
+	delete(travelStarts, d.Name)
+
+	if state == "Opening" || state == "Closing" {
+		travelStarts[d.Name] = &travelStart{state: state, at: d.LastUpdate, firmwareAt: d.Status.LastDoorUpdateAt, upTime: d.Status.UpTime}
+		return
+	}
+	if start == nil {
+		return
+	}
+	var direction string
+	switch {
+	case start.state == "Opening" && state == "Open":
+		direction = "opening"
+	case start.state == "Closing" && state == "Closed":
+		direction = "closing"
+	default:
+		// stopped or reversed half way
+		return
+	}
+	var travel time.Duration
+	switch {
+	// the firmware knows when the door changed, no matter how often it's polled
+	case start.firmwareAt > 0 && d.Status.LastDoorUpdateAt > start.firmwareAt && d.Status.UpTime >= start.upTime:
+		travel = time.Duration(d.Status.LastDoorUpdateAt-start.firmwareAt) * time.Millisecond
+	case !start.missed:
+		travel = d.LastUpdate.Sub(start.at)
+	default:
+		return
+	}
+	doorTravel.WithLabelValues(targetLocation(d.Name), d.Name, targetDisplayName(d.Name), direction).Observe(travel.Seconds())
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	ansiReset      = "\033[0m"
+	ansiBold       = "\033[1m"
+	ansiRed        = "\033[31m"
+	ansiGreen      = "\033[32m"
+	ansiYellow     = "\033[33m"
+	ansiDim        = "\033[2m"
+	ansiClear      = "\033[H\033[2J"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+)
+
+// runTUI polls the devices directly and redraws a dashboard until interrupted.
+func runTUI() {
+	interval := pollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	// errors are shown in the dashboard, they would only garble the screen
+	slog.SetDefault(slog.New(slog.NewTextHandler(ioutil.Discard, nil)))
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Print(ansiHideCursor)
+	defer fmt.Print(ansiShowCursor)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		collect(context.Background())
+		fmt.Print(ansiClear + renderTUI())
+
+		select {
+		case <-stop:
+			fmt.Println()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, (d%(24*time.Hour))/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", d/time.Hour, (d%time.Hour)/time.Minute)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm %ds", d/time.Minute, (d%time.Minute)/time.Second)
+	}
+	return fmt.Sprintf("%ds", d/time.Second)
+}
+
+func colored(color, s string) string {
+	return color + s + ansiReset
+}
+
+func renderTUI() string {
+	locked, unlock := lockDevices()
+	defer unlock()
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s  %s\n\n", colored(ansiBold, "homekit-ratgdo"), colored(ansiDim, time.Now().Format("15:04:05")+"  Ctrl-C to quit"))
+
+	// tabwriter counts the escape codes as width, so every cell in a column is colored the same way
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tDOOR\tOPEN FOR\tLIGHT\tMOTION\tOBSTRUCTED\tUPTIME\tFREE HEAP\tMIN HEAP\tCRASHES\t")
+	var errors []string
+	for _, d := range locked {
+		name := d.Name
+		if d.LastError != "" {
+			errors = append(errors, fmt.Sprintf("%s: %s", name, d.LastError))
+		}
+		if !d.Seen || !d.Online {
+			fmt.Fprintf(w, "%s\t%s\t\t\t\t\t\t\t\t\t\n", name, colored(ansiRed, "offline"))
+			continue
+		}
+
+		s := d.Status
+		door := strings.ToLower(s.GarageDoorState)
+		openFor := "-"
+		if door == "closed" {
+			door = colored(ansiGreen, door)
+		} else {
+			door = colored(ansiYellow, door)
+			openFor = humanDuration(d.openDuration())
+		}
+		obstructed := colored(ansiReset, "no")
+		if s.GarageObstructed {
+			obstructed = colored(ansiRed, "yes")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t\n",
+			name, door, openFor, onOff(s.GarageLightOn), onOff(s.GarageMotion), obstructed,
+			humanDuration(time.Duration(s.UpTime)*time.Millisecond), s.FreeHeap, s.MinHeap, s.CrashCount)
+	}
+	w.Flush()
+	for _, e := range errors {
+		fmt.Fprintln(&buf, colored(ansiRed, e))
+	}
+
+	fmt.Fprintf(&buf, "\n%s\n", colored(ansiBold, "Recent events"))
+	shown := 0
+	for i := len(eventLog) - 1; i >= 0 && shown < 10; i-- {
+		e := eventLog[i]
+		fmt.Fprintf(&buf, "%s  %-12s %-13s %s → %s\n", colored(ansiDim, e.Time.Format("15:04:05")), e.Device, e.Type, e.From, e.To)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Fprintln(&buf, colored(ansiDim, "none yet"))
+	}
+	return buf.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"html"
+	"net/http"
+)
+
+//go:embed ui/index.html
+var indexHTML []byte
+
+func uiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(bytes.Replace(indexHTML, []byte("{{version}}"), []byte(html.EscapeString(versionString())), 1))
+}
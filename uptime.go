@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// uptimeCollector exposes the uptime of the devices as a counter, which
+// starts over when a device reboots, and as the time it booted, so rate() and
+// resets() work on it.
+type uptimeCollector struct {
+	uptime   *prometheus.Desc
+	bootTime *prometheus.Desc
+}
+
+func init() {
+	prometheus.MustRegister(uptimeCollector{
+		uptime:   prometheus.NewDesc("homekit_ratgdo_uptime_seconds_total", "Seconds the garage door controller has been running since it booted.", deviceLabelNames, nil),
+		bootTime: prometheus.NewDesc("homekit_ratgdo_boot_time_seconds", "Unix time the garage door controller booted.", deviceLabelNames, nil),
+	})
+}
+
+func (c uptimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.uptime
+	ch <- c.bootTime
+}
+
+func (c uptimeCollector) Collect(ch chan<- prometheus.Metric) {
+	// the registry refuses a scrape with the same series twice
+	collected := map[string]bool{}
+	for _, d := range allDevices() {
+		d.mu.Lock()
+		s := d.Status
+		labels := deviceLabels(d.Name, s)
+		key := strings.Join(labels, "\x00")
+		if d.Seen && !collected[key] {
+			collected[key] = true
+			up := time.Duration(s.UpTime) * time.Millisecond
+			ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.CounterValue, up.Seconds(), labels...)
+			ch <- prometheus.MustNewConstMetric(c.bootTime, prometheus.GaugeValue, float64(d.LastUpdate.Add(-up).Unix()), labels...)
+		}
+		d.mu.Unlock()
+	}
+}
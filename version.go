@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// otherwise they come from what the Go toolchain recorded, if anything.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+
+	showVersion bool
+)
+
+func init() {
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+			case s.Key == "vcs.time" && buildDate == "":
+				buildDate = s.Value
+			}
+		}
+	}
+	if version == "" {
+		version = "dev"
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "homekit_ratgdo_exporter_build_info",
+		Help: "Always 1, labeled with the version, commit, build date and Go version of the exporter.",
+		ConstLabels: prometheus.Labels{
+			"version":   version,
+			"revision":  commit,
+			"builddate": buildDate,
+			"goversion": runtime.Version(),
+		},
+	})
+	buildInfo.Set(1)
+	prometheus.MustRegister(buildInfo)
+}
+
+func versionString() string {
+	return fmt.Sprintf("homekit-ratgdo-exporter %s (commit %s, built %s, %s %s/%s)", version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	vmURL      string
+	vmInterval time.Duration
+	vmFormat   string
+
+	vmPushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_victoriametrics_pushes_total",
+		Help: "Pushes of the metrics to VictoriaMetrics, labeled by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	flag.StringVar(&vmURL, "victoriametrics.url", "", "VictoriaMetrics to push the metrics to every -victoriametrics.interval, e.g. http://victoria:8428 (disabled when empty)")
+	flag.DurationVar(&vmInterval, "victoriametrics.interval", time.Minute, "How often the metrics are pushed to -victoriametrics.url")
+	flag.StringVar(&vmFormat, "victoriametrics.format", "prometheus", "Format the metrics are pushed to VictoriaMetrics in: prometheus, to /api/v1/import/prometheus, or json, JSON lines to /api/v1/import")
+
+	prometheus.MustRegister(vmPushes)
+}
+
+func startVictoriaMetrics() error {
+	u, err := url.Parse(vmURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid -victoriametrics.url %q", vmURL)
+	}
+	if vmInterval <= 0 {
+		return fmt.Errorf("-victoriametrics.interval has to be positive")
+	}
+	path := map[string]string{"prometheus": "/api/v1/import/prometheus", "json": "/api/v1/import"}[vmFormat]
+	if path == "" {
+		return fmt.Errorf("invalid -victoriametrics.format %q, use prometheus or json", vmFormat)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = path
+	}
+	vmURL = u.String()
+	slog.Info("Pushing metrics to VictoriaMetrics", "url", u.Redacted(), "interval", vmInterval, "format", vmFormat)
+	go pushVictoriaMetrics()
+	return nil
+}
+
+// pushVictoriaMetrics collects the devices and pushes the metrics, like a
+// scrape would get them, every -victoriametrics.interval.
+func pushVictoriaMetrics() {
+	defer reportPanic()
+	for {
+		time.Sleep(vmInterval)
+		// on standby only the leader pushes, like it fetches
+		if !isLeader() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), vmInterval)
+		heartbeat(collect(ctx))
+		cancel()
+		result := "success"
+		if err := postVictoriaMetrics(); err != nil {
+			slog.Error("Error pushing to VictoriaMetrics", "err", err)
+			result = "failure"
+		}
+		vmPushes.WithLabelValues(result).Inc()
+	}
+}
+
+func postVictoriaMetrics() error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if vmFormat == "json" {
+		err = writeVMJSON(zw, families, time.Now())
+	} else {
+		for _, f := range families {
+			if _, err = expfmt.MetricFamilyToText(zw, f); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, vmURL, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("User-Agent", "homekit-ratgdo-exporter/"+version)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// writeVMJSON writes every sample as a line of VictoriaMetrics' JSON import
+// format. Histograms and summaries are split into their classic series.
+func writeVMJSON(w io.Writer, families []*dto.MetricFamily, now time.Time) error {
+	enc := json.NewEncoder(w)
+	ts := now.UnixMilli()
+	for _, f := range families {
+		for _, m := range f.Metric {
+			sample := func(suffix string, v float64, extra ...string) error {
+				labels := map[string]string{"__name__": f.GetName() + suffix}
+				for _, l := range m.Label {
+					labels[l.GetName()] = l.GetValue()
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels[extra[i]] = extra[i+1]
+				}
+				t := ts
+				if m.TimestampMs != nil {
+					t = m.GetTimestampMs()
+				}
+				// JSON has no NaN or infinities
+				if math.IsNaN(v) || math.IsInf(v, 0) {
+					return nil
+				}
+				return enc.Encode(map[string]interface{}{"metric": labels, "values": []float64{v}, "timestamps": []int64{t}})
+			}
+			var err error
+			switch {
+			case m.Counter != nil:
+				err = sample("", m.Counter.GetValue())
+			case m.Gauge != nil:
+				err = sample("", m.Gauge.GetValue())
+			case m.Untyped != nil:
+				err = sample("", m.Untyped.GetValue())
+			case m.Histogram != nil:
+				h := m.Histogram
+				for _, b := range h.Bucket {
+					if err = sample("_bucket", float64(b.GetCumulativeCount()), "le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)); err != nil {
+						return err
+					}
+				}
+				if err = sample("_bucket", float64(h.GetSampleCount()), "le", "+Inf"); err == nil {
+					if err = sample("_sum", h.GetSampleSum()); err == nil {
+						err = sample("_count", float64(h.GetSampleCount()))
+					}
+				}
+			case m.Summary != nil:
+				s := m.Summary
+				for _, q := range s.Quantile {
+					if err = sample("", q.GetValue(), "quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)); err != nil {
+						return err
+					}
+				}
+				if err = sample("_sum", s.GetSampleSum()); err == nil {
+					err = sample("_count", float64(s.GetSampleCount()))
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
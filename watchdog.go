@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	watchdogUnreachableFor time.Duration
+	watchdogHeapBelow      int
+	watchdogHeapFor        time.Duration
+	watchdogMinInterval    time.Duration
+
+	watchdogReboots = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "homekit_ratgdo_watchdog_reboots_total",
+		Help: "Reboots triggered by the watchdog, labeled by device, reason (unreachable or low_heap) and result.",
+	}, []string{"device", "reason", "result"})
+
+	// guarded by mutex
+	lowHeapSince   = map[string]time.Time{}
+	offlineSince   = map[string]time.Time{}
+	watchdogReboot = map[string]time.Time{}
+)
+
+func init() {
+	flag.DurationVar(&watchdogUnreachableFor, "watchdog.unreachable-for", 0, "Reboot a device that has been unreachable this long (0 disables)")
+	flag.IntVar(&watchdogHeapBelow, "watchdog.heap-below", 0, "Reboot a device whose free heap has been below this many bytes for -watchdog.heap-for (0 disables)")
+	flag.DurationVar(&watchdogHeapFor, "watchdog.heap-for", 10*time.Minute, "How long the free heap has to be low before the watchdog reboots the device")
+	flag.DurationVar(&watchdogMinInterval, "watchdog.min-interval", time.Hour, "Minimum time between two watchdog reboots of the same device")
+
+	prometheus.MustRegister(watchdogReboots)
+	onUpdate(watchdog)
+}
+
+// watchdog reboots a device that is unreachable or running out of memory. It
+// never reboots while the door is, or was last seen, moving.
+func watchdog(d *deviceState) {
+	if watchdogUnreachableFor <= 0 && watchdogHeapBelow <= 0 {
+		return
+	}
+	now := time.Now()
+
+	if d.Online {
+		delete(offlineSince, d.Name)
+	} else if _, ok := offlineSince[d.Name]; !ok {
+		offlineSince[d.Name] = now
+	}
+	if d.Online && d.Status.FreeHeap < watchdogHeapBelow {
+		if _, ok := lowHeapSince[d.Name]; !ok {
+			lowHeapSince[d.Name] = now
+		}
+	} else {
+		delete(lowHeapSince, d.Name)
+	}
+
+	var reason string
+	var since time.Duration
+	if t, ok := offlineSince[d.Name]; ok && watchdogUnreachableFor > 0 && now.Sub(t) >= watchdogUnreachableFor {
+		reason, since = "unreachable", now.Sub(t)
+	} else if t, ok := lowHeapSince[d.Name]; ok && watchdogHeapBelow > 0 && now.Sub(t) >= watchdogHeapFor {
+		reason, since = "low_heap", now.Sub(t)
+	} else {
+		return
+	}
+
+	if doorMoving(d) || inMaintenance(d.Name) || now.Sub(watchdogReboot[d.Name]) < watchdogMinInterval {
+		return
+	}
+	t, ok := findTarget(d.Name)
+	if !ok {
+		return
+	}
+	watchdogReboot[d.Name] = now
+	delete(offlineSince, d.Name)
+	delete(lowHeapSince, d.Name)
+
+	var message string
+	if reason == "unreachable" {
+		message = fmt.Sprintf("Rebooting the device, it has been unreachable for %s", humanDuration(since))
+	} else {
+		message = fmt.Sprintf("Rebooting the device, free heap has been %d bytes for %s", d.Status.FreeHeap, humanDuration(since))
+	}
+	slog.Warn("Watchdog rebooting device", "device", d.Name, "reason", message)
+	pushLoki(lokiEntry{time: now, device: d.Name, kind: "reboot", line: map[string]string{
+		"event": "watchdog.reboot", "reason": reason, "message": message, "displayName": targetDisplayName(d.Name),
+	}})
+	notify(Notification{
+		Event:    "watchdog.reboot",
+		Device:   d.Name,
+		Title:    displayName(d.Name),
+		Message:  message,
+		Severity: severityWarning,
+		Time:     now,
+	})
+
+	go func() {
+		entry := auditEntry{Actor: "watchdog", Device: d.Name, Control: "device", Action: "reboot", Result: "success"}
+		defer func() { recordAudit(entry) }()
+		if err := postDevice(t, "/reboot", nil); err != nil {
+			slog.Error("Error rebooting device", "device", d.Name, "err", err)
+			entry.Result, entry.Error = "failure", err.Error()
+			watchdogReboots.WithLabelValues(d.Name, reason, "failure").Inc()
+			return
+		}
+		watchdogReboots.WithLabelValues(d.Name, reason, "success").Inc()
+	}()
+}
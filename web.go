@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringList is a flag that can be given several times, or with comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+var (
+	webListenAddresses stringList
+	webSocketMode      string
+	webTLSCert         string
+	webTLSKey          string
+	webTLSCA           string
+)
+
+func init() {
+	flag.Var(&webListenAddresses, "web.listen-address", "Address to listen on, like :9987, 10.0.0.5:9987 or unix:///run/ratgdo-exporter.sock; repeat it or separate with commas for several (default :8080)")
+	flag.StringVar(&webSocketMode, "web.socket-mode", "0660", "Permissions of the socket of a unix:// -web.listen-address")
+	flag.StringVar(&webTLSCert, "web.tls-cert", "", "Certificate file to serve HTTPS with, together with -web.tls-key")
+	flag.StringVar(&webTLSKey, "web.tls-key", "", "Private key file of -web.tls-cert")
+	flag.StringVar(&webTLSCA, "web.tls-client-ca", "", "Only accept clients with a certificate signed by a CA in this file (needs -web.tls-cert)")
+}
+
+// certPool reads the PEM certificates in a file.
+func certPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// requireAuth lets only requests with one of the basicAuthUsers, a static
+// bearer token or an API token through to h, if any of the first two are set.
+// The health checks stay open for probes.
+func requireAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		open := r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+		users, tokens := currentConfig().BasicAuthUsers, currentBearerTokens()
+		if open || len(users) == 0 && len(tokens) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if validBearerToken(r, tokens) || authenticate(r) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if user, password, ok := r.BasicAuth(); ok && checkPassword(user, password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(users) > 0 {
+			w.Header().Add("WWW-Authenticate", `Basic realm="homekit-ratgdo-exporter", charset="UTF-8"`)
+		}
+		if len(tokens) > 0 {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="homekit-ratgdo-exporter"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// webAddresses returns -web.listen-address, or the deprecated -port as the only address.
+func webAddresses() []string {
+	if len(webListenAddresses) > 0 {
+		return webListenAddresses
+	}
+	return []string{":" + port}
+}
+
+// webListener listens on one of the web addresses.
+func webListener(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix://")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	mode, err := strconv.ParseUint(webSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -web.socket-mode %q", webSocketMode)
+	}
+	// left behind if the exporter was killed
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serveAll listens on every web address before serving any, so a bad one
+// fails at startup, and returns the first error.
+func serveAll(server *http.Server, msg string, serve func(net.Listener) error) error {
+	var listeners []net.Listener
+	for _, address := range webAddresses() {
+		l, err := webListener(address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		slog.Info(msg, "address", l.Addr().String())
+		go func(l net.Listener) { errs <- serve(l) }(l)
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-quit:
+		// streams never finish by themselves, so don't wait for them long
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+		return nil
+	}
+}
+
+// listen serves mux on -web.listen-address, over HTTPS when a certificate is given.
+func listen(mux *http.ServeMux) error {
+	if err := loadBearerTokens(); err != nil {
+		return err
+	}
+	if err := validateAccessLog(); err != nil {
+		return err
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" {
+			slog.Warn("-port is deprecated, use -web.listen-address :" + port)
+		}
+	})
+	server := &http.Server{
+		Handler:  logRequests(traceRequests(reportPanics(cors(requireAuth(mux))))),
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	if webTLSCert == "" && webTLSKey == "" {
+		if webTLSCA != "" {
+			return errors.New("-web.tls-client-ca needs -web.tls-cert and -web.tls-key")
+		}
+		return serveAll(server, "Starting server", server.Serve)
+	}
+	if webTLSCert == "" || webTLSKey == "" {
+		return errors.New("-web.tls-cert and -web.tls-key have to be given together")
+	}
+	// fail at startup rather than on the first connection
+	if _, err := tls.LoadX509KeyPair(webTLSCert, webTLSKey); err != nil {
+		return err
+	}
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if webTLSCA != "" {
+		pool, err := certPool(webTLSCA)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		slog.Info("Requiring client certificates", "ca", webTLSCA)
+	}
+	return serveAll(server, "Starting HTTPS server", func(l net.Listener) error {
+		return server.ServeTLS(l, webTLSCert, webTLSKey)
+	})
+}
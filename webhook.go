@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WebhookConfig is a URL that events are POSTed to.
+type WebhookConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Events selects the events to send by name, e.g. "door.open" or "connectivity.*". All events when empty.
+	Events []string `json:"events"`
+	// Template is a text/template for the body, executed with a webhookPayload. JSON of the payload when empty.
+	Template    string            `json:"template"`
+	ContentType string            `json:"contentType"`
+	Headers     map[string]string `json:"headers"`
+	// Secret signs the body with HMAC-SHA256 in the X-Ratgdo-Signature header.
+	Secret  string   `json:"secret"`
+	Retries int      `json:"retries"`
+	Timeout duration `json:"timeout"`
+
+	template *template.Template
+}
+
+// webhookPayload is what webhook templates are executed with.
+type webhookPayload struct {
+	Event  string          `json:"event"`
+	Time   time.Time       `json:"time"`
+	Device string          `json:"device"`
+	Type   string          `json:"type"`
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Status *statusSnapshot `json:"status,omitempty"`
+}
+
+var webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "homekit_ratgdo_webhook_deliveries_total",
+	Help: "Webhook deliveries, labeled by webhook and whether they succeeded.",
+}, []string{"webhook", "result"})
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries)
+
+	onEvent(func(e Event) {
+		cfg := currentConfig()
+		for i := range cfg.Webhooks {
+			w := &cfg.Webhooks[i]
+			if !w.wants(e) {
+				continue
+			}
+			payload := webhookPayload{Event: e.Name(), Time: e.Time, Device: e.Device, Type: e.Type, From: e.From, To: e.To}
+			if d, ok := allDevices()[e.Device]; ok {
+				s := snapshot(d)
+				payload.Status = &s
+			}
+			go w.deliver(payload)
+		}
+	})
+}
+
+// Name is the event name used to select events, e.g. door.open or connectivity.offline.
+func (e Event) Name() string {
+	return e.Type + "." + strings.ToLower(e.To)
+}
+
+func (w *WebhookConfig) validate() error {
+	if w.URL == "" {
+		return errors.New("url is required")
+	}
+	if w.Name == "" {
+		w.Name = w.URL
+	}
+	for _, pattern := range w.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid event pattern %q", pattern)
+		}
+	}
+	if w.Template != "" {
+		t, err := template.New(w.Name).Parse(w.Template)
+		if err != nil {
+			return err
+		}
+		w.template = t
+	}
+	if w.Timeout == 0 {
+		w.Timeout = duration(10 * time.Second)
+	}
+	return nil
+}
+
+func (w *WebhookConfig) wants(e Event) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, pattern := range w.Events {
+		if ok, _ := path.Match(pattern, e.Name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WebhookConfig) body(p webhookPayload) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(p)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *WebhookConfig) deliver(p webhookPayload) {
+	body, err := w.body(p)
+	if err != nil {
+		slog.Error("Error rendering webhook", "webhook", w.Name, "err", err)
+		webhookDeliveries.WithLabelValues(w.Name, "failure").Inc()
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err = w.post(p.Event, body)
+		if err == nil {
+			webhookDeliveries.WithLabelValues(w.Name, "success").Inc()
+			return
+		}
+		if attempt >= w.Retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	slog.Error("Error delivering webhook", "webhook", w.Name, "event", p.Event, "err", err)
+	webhookDeliveries.WithLabelValues(w.Name, "failure").Inc()
+}
+
+func (w *WebhookConfig) post(event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ratgdo-Event", event)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Ratgdo-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: time.Duration(w.Timeout)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+
+// wsHandler mirrors /stream over a WebSocket, sending every streamMessage as JSON.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("Error upgrading WebSocket connection", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	// clients that can't send ping frames can send {"type":"ping"} instead
+	pings := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var m streamMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+			if m.Type == "ping" {
+				select {
+				case pings <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	visible := visibleDevices(r)
+	c := subscribe()
+	defer unsubscribe(c)
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-pings:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(streamMessage{Type: "pong"})
+		case m := <-c:
+			if visible != nil && !visible[streamDevice(m)] {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(m)
+		}
+		if err != nil {
+			return
+		}
+	}
+}